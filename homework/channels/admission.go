@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var ErrInvalidPriority = errors.New("invalid task priority")
+
+// WithPriorityLanes enables priority lanes with the given weights, the
+// priority of a task is the index of its lane. Lanes are served by smooth
+// weighted round robin, so with weights 3 and 1 the lane 0 gets three tasks
+// of every four while both lanes have tasks. Each lane has the queue size.
+func WithPriorityLanes(weights ...int) Option {
+	return func(wp *WorkerPool) {
+		wp.weights = weights
+	}
+}
+
+// WithRateLimit limits the rate tasks are handed to workers to limit
+// tasks per second with bursts of up to burst tasks. Keyed tasks share
+// the limit, but they are not put into priority lanes.
+func WithRateLimit(limit float64, burst int) Option {
+	return func(wp *WorkerPool) {
+		wp.limit = limit
+		wp.burst = burst
+	}
+}
+
+// WithClock sets the clock of the rate limiter.
+func WithClock(clock Clock) Option {
+	return func(wp *WorkerPool) {
+		wp.clock = clock
+	}
+}
+
+// tokenBucket is the rate limiter shared by the dispatcher and keyed lanes
+type tokenBucket struct {
+	mu     sync.Mutex
+	clock  Clock
+	limit  float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(clock Clock, limit float64, burst int) *tokenBucket {
+	b := &tokenBucket{
+		clock: clock,
+		limit: limit,
+		burst: float64(max(burst, 1)),
+		last:  clock.Now(),
+	}
+	b.tokens = b.burst
+	return b
+}
+
+// take waits for a token, it returns false if abort is closed first
+func (b *tokenBucket) take(abort <-chan struct{}) bool {
+	for {
+		wait, ok := b.reserve()
+		if ok {
+			return true
+		}
+
+		select {
+		case <-b.clock.After(wait):
+		case <-abort:
+			return false
+		}
+	}
+}
+
+// reserve takes a token if there is one, otherwise
+// it returns how long to wait for the next one
+func (b *tokenBucket) reserve() (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.limit)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+
+	return time.Duration(math.Ceil((1 - b.tokens) / b.limit * float64(time.Second))), false
+}
+
+// admit waits for the rate limiter, it returns false if the shutdown is forced
+func (wp *WorkerPool) admit() bool {
+	return wp.limiter == nil || wp.limiter.take(wp.abort)
+}
+
+// admission holds tasks in priority lanes until the
+// dispatcher hands them to workers
+type admission struct {
+	lanes   []chan task
+	weights []int
+	// current are weights of smooth weighted round robin
+	current []int
+	// ready is signaled when a task is added to a lane
+	ready chan struct{}
+}
+
+// pick takes the task from the non-empty lane with the maximum current weight
+func (a *admission) pick() (task, bool) {
+	for {
+		best, total := -1, 0
+		for i, lane := range a.lanes {
+			if len(lane) == 0 {
+				continue
+			}
+			a.current[i] += a.weights[i]
+			total += a.weights[i]
+			if best < 0 || a.current[i] > a.current[best] {
+				best = i
+			}
+		}
+
+		if best < 0 {
+			return task{}, false
+		}
+		a.current[best] -= total
+
+		// drop oldest policy may take the task first
+		select {
+		case t := <-a.lanes[best]:
+			return t, true
+		default:
+		}
+	}
+}
+
+// startDispatcher runs the dispatcher if priority lanes or rate
+// limit are set, otherwise tasks are added to the queue directly
+func (wp *WorkerPool) startDispatcher() {
+	if len(wp.weights) == 0 && wp.limit <= 0 {
+		close(wp.dispatched)
+		return
+	}
+
+	a := &admission{
+		weights: make([]int, max(len(wp.weights), 1)),
+		ready:   make(chan struct{}, 1),
+	}
+	a.lanes = make([]chan task, len(a.weights))
+	a.current = make([]int, len(a.weights))
+	for i := range a.weights {
+		a.lanes[i] = make(chan task, wp.queueSize)
+		a.weights[i] = 1
+		if i < len(wp.weights) {
+			a.weights[i] = max(wp.weights[i], 1)
+		}
+	}
+	wp.admission = a
+
+	go wp.dispatch()
+}
+
+// dispatch hands tasks from the priority lanes to workers until
+// the lanes are empty after shutdown or the shutdown is forced
+func (wp *WorkerPool) dispatch() {
+	defer close(wp.dispatched)
+
+	a := wp.admission
+	for {
+		t, ok := a.pick()
+		if !ok {
+			select {
+			case <-a.ready:
+				continue
+			case <-wp.sealed:
+				// all tasks are in lanes already
+				if t, ok = a.pick(); !ok {
+					return
+				}
+			}
+		}
+
+		if !wp.admit() {
+			wp.discard(t)
+			return
+		}
+
+		select {
+		case wp.tasks <- t:
+		case <-wp.abort:
+			wp.discard(t)
+			return
+		}
+	}
+}
+
+// add adds the task to the queue or to
+// the lane 0 if the dispatcher is running
+func (wp *WorkerPool) add(ctx context.Context, t task) error {
+	if wp.admission == nil {
+		return wp.enqueue(ctx, wp.tasks, t)
+	}
+	return wp.addPriority(ctx, 0, t)
+}
+
+func (wp *WorkerPool) addPriority(ctx context.Context, priority int, t task) error {
+	a := wp.admission
+	if a == nil || priority < 0 || priority >= len(a.lanes) {
+		return ErrInvalidPriority
+	}
+
+	if err := wp.enqueue(ctx, a.lanes[priority], t); err != nil {
+		return err
+	}
+
+	select {
+	case a.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// AddPriorityTask adds the task to the priority lane,
+// see WithPriorityLanes.
+func (wp *WorkerPool) AddPriorityTask(priority int, fn func()) error {
+	return wp.AddPriorityTaskContext(context.Background(), priority, fn)
+}
+
+// AddPriorityTaskContext is AddPriorityTask which stops waiting
+// for room in the lane when the context is done.
+func (wp *WorkerPool) AddPriorityTaskContext(ctx context.Context, priority int, fn func()) error {
+	return wp.addPriority(ctx, priority, task{run: func(context.Context) { fn() }})
+}
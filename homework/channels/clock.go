@@ -0,0 +1,10 @@
+package main
+
+import (
+	"golang_course/internal/clock"
+)
+
+// Clock is a source of time for the rate limiter, tests inject a fake one.
+type Clock = clock.Clock
+
+type realClock = clock.Real
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+)
+
+// Future is a result of the task submitted by Submit.
+type Future[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func newFuture[T any]() *Future[T] {
+	return &Future[T]{done: make(chan struct{})}
+}
+
+func (f *Future[T]) resolve(value T, err error) {
+	f.value = value
+	f.err = err
+	close(f.done)
+}
+
+// Done returns a channel which is closed when the result is ready.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Get waits for the result of the task or until the context is done.
+// A panic of the task is returned as *PanicError.
+func (f *Future[T]) Get(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.value, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// Submit adds the task to the pool and returns its future result. If the task
+// can't be added or is dropped from the queue the future holds that error.
+func Submit[T any](pool *WorkerPool, fn func(ctx context.Context) (T, error)) *Future[T] {
+	future := newFuture[T]()
+
+	t := task{
+		run: func(ctx context.Context) {
+			var (
+				value T
+				err   error
+			)
+			defer func() {
+				if r := recover(); r != nil {
+					err = pool.panicked(r)
+				}
+				future.resolve(value, err)
+			}()
+
+			value, err = fn(ctx)
+		},
+		discard: func(err error) {
+			var zero T
+			future.resolve(zero, err)
+		},
+	}
+
+	if err := pool.add(context.Background(), t); err != nil {
+		t.discard(err)
+	}
+
+	return future
+}
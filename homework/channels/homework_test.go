@@ -1,62 +1,21 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
-)
-
-// go test -v homework_test.go
-
-type WorkerPool struct {
-	tasks chan func()
-	wg    sync.WaitGroup
-}
-
-func NewWorkerPool(workersNumber int) *WorkerPool {
-	wp := &WorkerPool{
-		tasks: make(chan func(), workersNumber),
-	}
 
-	wp.wg.Add(workersNumber)
-
-	// run workers
-	for i := 0; i < workersNumber; i++ {
-		go func() {
-			defer wp.wg.Done()
-			for t := range wp.tasks {
-				t()
-			}
-		}()
-	}
-
-	return wp
-}
-
-// Return an error if the pool is full
-func (wp *WorkerPool) AddTask(task func()) (err error) {
-	if len(wp.tasks) >= cap(wp.tasks) {
-		err = errors.New("worker pull is full")
-		go func() {
-			wp.tasks <- task
-		}()
-	} else {
-		wp.tasks <- task
-	}
-
-	return
-}
+	"golang_course/internal/clock"
+)
 
-// Shutdown all workers and wait for all
-// tasks in the pool to complete
-func (wp *WorkerPool) Shutdown() {
-	close(wp.tasks)
-	wp.wg.Wait()
-}
+// go test -v .
 
 func TestWorkerPool(t *testing.T) {
 	var counter atomic.Int32
@@ -79,7 +38,652 @@ func TestWorkerPool(t *testing.T) {
 	_ = pool.AddTask(task)
 	_ = pool.AddTask(task)
 	_ = pool.AddTask(task)
-	pool.Shutdown() // wait tasks
+	pool.Shutdown(context.Background()) // wait tasks
 
 	assert.Equal(t, int32(6), counter.Load())
 }
+
+func TestWorkerPoolOverflow(t *testing.T) {
+	// blocker occupies the only worker until released
+	newBlockedPool := func(options ...Option) (*WorkerPool, chan struct{}) {
+		release := make(chan struct{})
+		started := make(chan struct{})
+
+		pool := NewWorkerPool(1, options...)
+		_ = pool.AddTask(func() {
+			close(started)
+			<-release
+		})
+		<-started
+
+		return pool, release
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		pool, release := newBlockedPool(WithQueueSize(2), WithOverflowPolicy(OverflowReject))
+
+		var counter atomic.Int32
+		task := func() { counter.Add(1) }
+
+		assert.NoError(t, pool.AddTask(task))
+		assert.NoError(t, pool.AddTask(task))
+		assert.ErrorIs(t, pool.AddTask(task), ErrPoolFull)
+		assert.ErrorIs(t, pool.AddTask(task), ErrPoolFull)
+
+		close(release)
+		pool.Shutdown(context.Background())
+
+		// rejected tasks are really rejected
+		assert.Equal(t, int32(2), counter.Load())
+	})
+
+	t.Run("Block With Context", func(t *testing.T) {
+		pool, release := newBlockedPool(WithQueueSize(1))
+
+		var counter atomic.Int32
+		task := func() { counter.Add(1) }
+		assert.NoError(t, pool.AddTask(task))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, pool.AddTaskContext(ctx, task), context.DeadlineExceeded)
+
+		added := make(chan error)
+		go func() {
+			added <- pool.AddTask(task)
+		}()
+
+		select {
+		case <-added:
+			assert.Fail(t, "task is added to the full queue")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		assert.NoError(t, <-added)
+		pool.Shutdown(context.Background())
+
+		assert.Equal(t, int32(2), counter.Load())
+	})
+
+	t.Run("Drop Oldest", func(t *testing.T) {
+		pool, release := newBlockedPool(WithQueueSize(2), WithOverflowPolicy(OverflowDropOldest))
+
+		var (
+			mu       sync.Mutex
+			executed []int
+		)
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, pool.AddTask(func() {
+				mu.Lock()
+				defer mu.Unlock()
+				executed = append(executed, i)
+			}))
+		}
+
+		close(release)
+		pool.Shutdown(context.Background())
+
+		assert.Equal(t, []int{3, 4}, executed)
+		assert.Equal(t, 3, pool.Dropped())
+	})
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	t.Run("Invalid Size", func(t *testing.T) {
+		pool := NewWorkerPool(1)
+		defer pool.Shutdown(context.Background())
+
+		assert.ErrorIs(t, pool.Resize(0), ErrInvalidWorkersSize)
+		assert.Equal(t, 1, pool.Workers())
+	})
+
+	t.Run("Grow", func(t *testing.T) {
+		pool := NewWorkerPool(1, WithQueueSize(10))
+
+		var (
+			running    atomic.Int32
+			maxRunning atomic.Int32
+		)
+		task := func() {
+			n := running.Add(1)
+			for {
+				current := maxRunning.Load()
+				if n <= current || maxRunning.CompareAndSwap(current, n) {
+					break
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+			running.Add(-1)
+		}
+
+		assert.NoError(t, pool.Resize(4))
+		assert.Equal(t, 4, pool.Workers())
+
+		for i := 0; i < 8; i++ {
+			assert.NoError(t, pool.AddTask(task))
+		}
+		pool.Shutdown(context.Background())
+
+		assert.Equal(t, int32(4), maxRunning.Load())
+	})
+
+	t.Run("Shrink Without Losing Tasks", func(t *testing.T) {
+		pool := NewWorkerPool(4, WithQueueSize(100))
+
+		var counter atomic.Int32
+		task := func() {
+			time.Sleep(10 * time.Millisecond)
+			counter.Add(1)
+		}
+
+		for i := 0; i < 50; i++ {
+			assert.NoError(t, pool.AddTask(task))
+		}
+
+		assert.NoError(t, pool.Resize(1))
+		assert.Equal(t, 1, pool.Workers())
+
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, pool.AddTask(task))
+		}
+
+		assert.NoError(t, pool.Resize(2))
+		pool.Shutdown(context.Background())
+
+		assert.Equal(t, int32(60), counter.Load())
+	})
+
+	t.Run("Shrink Busy Pool Then Force Shutdown", func(t *testing.T) {
+		pool := NewWorkerPool(2)
+
+		var started sync.WaitGroup
+		started.Add(2)
+		for i := 0; i < 2; i++ {
+			_ = Submit(pool, func(ctx context.Context) (int, error) {
+				started.Done()
+				<-ctx.Done()
+				return 0, ctx.Err()
+			})
+		}
+		started.Wait()
+
+		assert.NoError(t, pool.Resize(1))
+		assert.Equal(t, 1, pool.Workers())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		report, err := pool.Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 2, report.Interrupted)
+	})
+}
+
+func TestSubmit(t *testing.T) {
+	t.Run("Result And Error", func(t *testing.T) {
+		pool := NewWorkerPool(2)
+		defer pool.Shutdown(context.Background())
+
+		errTask := errors.New("task error")
+
+		sum := Submit(pool, func(ctx context.Context) (int, error) {
+			return 2 + 2, nil
+		})
+		failed := Submit(pool, func(ctx context.Context) (string, error) {
+			return "", errTask
+		})
+
+		value, err := sum.Get(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 4, value)
+
+		_, err = failed.Get(context.Background())
+		assert.ErrorIs(t, err, errTask)
+	})
+
+	t.Run("Panic", func(t *testing.T) {
+		var panics atomic.Int32
+		pool := NewWorkerPool(1, WithOnPanic(func(err *PanicError) {
+			panics.Add(1)
+		}))
+		defer pool.Shutdown(context.Background())
+
+		errBoom := errors.New("boom")
+		future := Submit(pool, func(ctx context.Context) (int, error) {
+			panic(errBoom)
+		})
+
+		_, err := future.Get(context.Background())
+
+		var panicErr *PanicError
+		assert.ErrorAs(t, err, &panicErr)
+		assert.ErrorIs(t, err, errBoom)
+		assert.Contains(t, string(panicErr.Stack), "TestSubmit")
+
+		// plain task panic doesn't kill the only worker
+		assert.NoError(t, pool.AddTask(func() { panic("plain") }))
+
+		value, err := Submit(pool, func(ctx context.Context) (int, error) {
+			return 1, nil
+		}).Get(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, value)
+
+		assert.Equal(t, int32(2), panics.Load())
+	})
+
+	t.Run("Get With Context", func(t *testing.T) {
+		pool := NewWorkerPool(1)
+		defer pool.Shutdown(context.Background())
+
+		release := make(chan struct{})
+		future := Submit(pool, func(ctx context.Context) (int, error) {
+			<-release
+			return 1, nil
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := future.Get(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		close(release)
+		<-future.Done()
+	})
+
+	t.Run("Rejected And Dropped", func(t *testing.T) {
+		release := make(chan struct{})
+		started := make(chan struct{})
+
+		pool := NewWorkerPool(1, WithQueueSize(1), WithOverflowPolicy(OverflowDropOldest))
+		_ = pool.AddTask(func() {
+			close(started)
+			<-release
+		})
+		<-started
+
+		task := func(ctx context.Context) (int, error) { return 1, nil }
+		first := Submit(pool, task)
+		second := Submit(pool, task)
+
+		_, err := first.Get(context.Background())
+		assert.ErrorIs(t, err, ErrTaskDropped)
+
+		close(release)
+		value, err := second.Get(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, value)
+		pool.Shutdown(context.Background())
+
+		rejecting := NewWorkerPool(1, WithQueueSize(1), WithOverflowPolicy(OverflowReject))
+		defer rejecting.Shutdown(context.Background())
+		block := make(chan struct{})
+		defer close(block)
+		_ = rejecting.AddTask(func() { <-block })
+		_ = rejecting.AddTask(func() { <-block })
+
+		_, err = Submit(rejecting, task).Get(context.Background())
+		assert.ErrorIs(t, err, ErrPoolFull)
+	})
+}
+
+func TestWorkerPoolShutdown(t *testing.T) {
+	t.Run("Graceful", func(t *testing.T) {
+		pool := NewWorkerPool(2, WithQueueSize(10))
+
+		var counter atomic.Int32
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, pool.AddTask(func() {
+				time.Sleep(5 * time.Millisecond)
+				counter.Add(1)
+			}))
+		}
+
+		report, err := pool.Shutdown(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, ShutdownReport{}, report)
+		assert.Equal(t, int32(10), counter.Load())
+
+		assert.ErrorIs(t, pool.AddTask(func() {}), ErrPoolClosed)
+		assert.ErrorIs(t, pool.Resize(3), ErrPoolClosed)
+
+		_, err = Submit(pool, func(ctx context.Context) (int, error) {
+			return 1, nil
+		}).Get(context.Background())
+		assert.ErrorIs(t, err, ErrPoolClosed)
+
+		// repeated shutdown is safe
+		_, err = pool.Shutdown(context.Background())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Forced", func(t *testing.T) {
+		pool := NewWorkerPool(1, WithQueueSize(10))
+
+		// the running task ignores its context
+		release := make(chan struct{})
+		started := make(chan struct{})
+		assert.NoError(t, pool.AddTask(func() {
+			close(started)
+			<-release
+		}))
+		<-started
+
+		queued := Submit(pool, func(ctx context.Context) (int, error) {
+			return 1, nil
+		})
+		var counter atomic.Int32
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, pool.AddTask(func() { counter.Add(1) }))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		report, err := pool.Shutdown(ctx)
+		close(release)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 5, report.NotRun)
+		assert.Equal(t, 1, report.Interrupted)
+		assert.Len(t, report.Pending, 4)
+		assert.Equal(t, report.NotRun, pool.Report().NotRun)
+		assert.Len(t, pool.Report().Pending, 4)
+
+		_, err = queued.Get(context.Background())
+		assert.ErrorIs(t, err, ErrPoolClosed)
+
+		// pending tasks may be run later
+		for _, task := range report.Pending {
+			task(context.Background())
+		}
+		assert.Equal(t, int32(4), counter.Load())
+	})
+
+	t.Run("Forced Cancels Task Contexts", func(t *testing.T) {
+		pool := NewWorkerPool(2)
+
+		var started sync.WaitGroup
+		started.Add(2)
+		running := Submit(pool, func(ctx context.Context) (int, error) {
+			started.Done()
+			<-ctx.Done()
+			return 0, ctx.Err()
+		})
+		canceled := make(chan error, 1)
+		assert.NoError(t, pool.AddTaskFunc(func(ctx context.Context) {
+			started.Done()
+			<-ctx.Done()
+			canceled <- ctx.Err()
+		}))
+		started.Wait()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		report, err := pool.Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, ShutdownReport{Interrupted: 2}, report)
+
+		_, err = running.Get(context.Background())
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, <-canceled, context.Canceled)
+	})
+
+	t.Run("Unblocks Adding Tasks", func(t *testing.T) {
+		pool := NewWorkerPool(1, WithQueueSize(1))
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		_ = pool.AddTask(func() {
+			close(started)
+			<-release
+		})
+		<-started
+		assert.NoError(t, pool.AddTask(func() {}))
+
+		added := make(chan error)
+		go func() {
+			added <- pool.AddTask(func() {})
+		}()
+
+		shutdown := make(chan struct{})
+		go func() {
+			defer close(shutdown)
+			_, _ = pool.Shutdown(context.Background())
+		}()
+
+		assert.ErrorIs(t, <-added, ErrPoolClosed)
+		close(release)
+		<-shutdown
+	})
+}
+
+func TestWorkerPoolKeyedTasks(t *testing.T) {
+	t.Run("Per Key FIFO", func(t *testing.T) {
+		const (
+			keys         = 8
+			tasksPerKey  = 200
+			producersNum = 4
+		)
+
+		pool := NewWorkerPool(4, WithQueueSize(16), WithKeyedLanes(3))
+
+		var (
+			mu       sync.Mutex
+			executed = make(map[string][]int)
+		)
+
+		// each producer owns its keys, so adding order per key is defined
+		var wg sync.WaitGroup
+		wg.Add(producersNum)
+		for p := 0; p < producersNum; p++ {
+			go func() {
+				defer wg.Done()
+				for i := 0; i < tasksPerKey; i++ {
+					for k := p; k < keys; k += producersNum {
+						key := fmt.Sprintf("user-%d", k)
+						assert.NoError(t, pool.AddKeyedTask(key, func() {
+							mu.Lock()
+							defer mu.Unlock()
+							executed[key] = append(executed[key], i)
+						}))
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		_, err := pool.Shutdown(context.Background())
+		assert.NoError(t, err)
+
+		assert.Len(t, executed, keys)
+		for key, order := range executed {
+			assert.Len(t, order, tasksPerKey, key)
+			assert.True(t, slices.IsSorted(order), key)
+		}
+	})
+
+	t.Run("Different Keys In Parallel", func(t *testing.T) {
+		pool := NewWorkerPool(1, WithKeyedLanes(16))
+		defer pool.Shutdown(context.Background())
+
+		// find two keys with different lanes
+		first, second := "a", "b"
+		for i := 0; pool.lane(first) == pool.lane(second); i++ {
+			second = fmt.Sprintf("b%d", i)
+		}
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		assert.NoError(t, pool.AddKeyedTask(first, func() {
+			close(started)
+			<-release
+		}))
+		<-started
+
+		done := make(chan struct{})
+		assert.NoError(t, pool.AddKeyedTask(second, func() { close(done) }))
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			assert.Fail(t, "key is blocked by another key")
+		}
+		close(release)
+	})
+
+	t.Run("Lanes Are Opt In", func(t *testing.T) {
+		pool := NewWorkerPool(2)
+		defer pool.Shutdown(context.Background())
+
+		assert.Empty(t, pool.lanes)
+		assert.ErrorIs(t, pool.AddKeyedTask("key", func() {}), ErrNoKeyedLanes)
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		pool := NewWorkerPool(1, WithQueueSize(10), WithKeyedLanes(1))
+
+		started := make(chan struct{})
+		assert.NoError(t, pool.AddKeyedTask("key", func() {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+		}))
+		<-started
+		for i := 0; i < 3; i++ {
+			assert.NoError(t, pool.AddKeyedTask("key", func() {}))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		report, err := pool.Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 3, report.NotRun)
+		assert.ErrorIs(t, pool.AddKeyedTask("key", func() {}), ErrPoolClosed)
+	})
+}
+
+func TestWorkerPoolAdmission(t *testing.T) {
+	t.Run("Rate Limit", func(t *testing.T) {
+		clock := clock.NewFake()
+		pool := NewWorkerPool(4, WithQueueSize(10), WithRateLimit(10, 2), WithClock(clock))
+
+		var counter atomic.Int32
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, pool.AddTask(func() { counter.Add(1) }))
+		}
+
+		// burst passes right away
+		assert.Eventually(t, func() bool { return counter.Load() == 2 }, time.Second, time.Millisecond)
+
+		for want := int32(3); want <= 5; want++ {
+			assert.Eventually(t, func() bool { return clock.Timers() == 1 }, time.Second, time.Millisecond)
+			assert.Equal(t, want-1, counter.Load())
+
+			clock.Advance(100 * time.Millisecond)
+			assert.Eventually(t, func() bool { return counter.Load() == want }, time.Second, time.Millisecond)
+		}
+
+		_, err := pool.Shutdown(context.Background())
+		assert.NoError(t, err)
+	})
+
+	t.Run("Rate Limit Keyed Tasks", func(t *testing.T) {
+		clock := clock.NewFake()
+		pool := NewWorkerPool(2, WithKeyedLanes(2), WithRateLimit(10, 1), WithClock(clock))
+
+		var counter atomic.Int32
+		task := func() { counter.Add(1) }
+		assert.NoError(t, pool.AddKeyedTask("a", task))
+		assert.NoError(t, pool.AddKeyedTask("b", task))
+		assert.NoError(t, pool.AddKeyedTask("a", task))
+		assert.NoError(t, pool.AddTask(task))
+
+		// keyed and plain tasks share one token per 100ms
+		assert.Eventually(t, func() bool { return counter.Load() == 1 }, time.Second, time.Millisecond)
+		for want := int32(2); want <= 4; want++ {
+			assert.Eventually(t, func() bool { return clock.Timers() > 0 }, time.Second, time.Millisecond)
+			assert.Never(t, func() bool { return counter.Load() >= want }, 20*time.Millisecond, time.Millisecond)
+
+			clock.Advance(100 * time.Millisecond)
+			assert.Eventually(t, func() bool { return counter.Load() == want }, time.Second, time.Millisecond)
+		}
+
+		_, err := pool.Shutdown(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, int32(4), counter.Load())
+	})
+
+	t.Run("Weighted Lanes", func(t *testing.T) {
+		const queueSize = 16
+		pool := NewWorkerPool(1, WithQueueSize(queueSize), WithPriorityLanes(3, 1))
+
+		release := make(chan struct{})
+		started := make(chan struct{})
+		assert.NoError(t, pool.AddTask(func() {
+			close(started)
+			<-release
+		}))
+		<-started
+
+		// fill the queue, then the dispatcher waits with one more task
+		for i := 0; i < queueSize+1; i++ {
+			assert.NoError(t, pool.AddPriorityTask(0, func() {}))
+		}
+		assert.Eventually(t, func() bool {
+			return len(pool.tasks) == queueSize && len(pool.admission.lanes[0]) == 0
+		}, time.Second, time.Millisecond)
+
+		var (
+			mu    sync.Mutex
+			lanes []int
+		)
+		for i := 0; i < 8; i++ {
+			for lane := 0; lane < 2; lane++ {
+				assert.NoError(t, pool.AddPriorityTask(lane, func() {
+					mu.Lock()
+					defer mu.Unlock()
+					lanes = append(lanes, lane)
+				}))
+			}
+		}
+
+		close(release)
+		_, err := pool.Shutdown(context.Background())
+		assert.NoError(t, err)
+
+		assert.Equal(t, []int{0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 1, 1, 1, 1, 1}, lanes)
+	})
+
+	t.Run("Invalid Priority", func(t *testing.T) {
+		pool := NewWorkerPool(1, WithPriorityLanes(2, 1))
+		defer pool.Shutdown(context.Background())
+
+		assert.ErrorIs(t, pool.AddPriorityTask(2, func() {}), ErrInvalidPriority)
+		assert.ErrorIs(t, pool.AddPriorityTask(-1, func() {}), ErrInvalidPriority)
+
+		plain := NewWorkerPool(1)
+		defer plain.Shutdown(context.Background())
+		assert.ErrorIs(t, plain.AddPriorityTask(0, func() {}), ErrInvalidPriority)
+	})
+
+	t.Run("Forced Shutdown While Limited", func(t *testing.T) {
+		clock := clock.NewFake()
+		pool := NewWorkerPool(1, WithQueueSize(10), WithRateLimit(1, 1), WithClock(clock))
+
+		var counter atomic.Int32
+		for i := 0; i < 4; i++ {
+			assert.NoError(t, pool.AddTask(func() { counter.Add(1) }))
+		}
+		assert.Eventually(t, func() bool { return clock.Timers() == 1 }, time.Second, time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		report, err := pool.Shutdown(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 3, report.NotRun)
+		assert.Equal(t, int32(1), counter.Load())
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+)
+
+var ErrNoKeyedLanes = errors.New("worker pool has no keyed lanes, use WithKeyedLanes")
+
+// WithKeyedLanes enables keyed tasks with n lanes. Every lane has its own
+// worker in addition to the pool workers and Resize doesn't change them,
+// so up to Workers() plus n tasks may run at once. Keyed tasks share
+// the rate limit of the pool, priority lanes don't apply to them.
+func WithKeyedLanes(n int) Option {
+	return func(wp *WorkerPool) {
+		wp.lanesSize = n
+	}
+}
+
+// startLanes runs one worker per lane if lanes are enabled
+func (wp *WorkerPool) startLanes() {
+	if wp.lanesSize <= 0 {
+		return
+	}
+
+	wp.lanes = make([]chan task, wp.lanesSize)
+	wp.wg.Add(len(wp.lanes))
+
+	for i := range wp.lanes {
+		lane := make(chan task, wp.queueSize)
+		wp.lanes[i] = lane
+
+		go func() {
+			defer wp.wg.Done()
+			for {
+				var t task
+				select {
+				case t = <-lane:
+				case <-wp.drain:
+					// the rest of the lane after shutdown starts
+					select {
+					case t = <-lane:
+					default:
+						return
+					}
+				}
+
+				if !wp.admit() {
+					wp.discard(t)
+					return
+				}
+				wp.runTask(t)
+			}
+		}()
+	}
+}
+
+// lane returns the queue of the key, the same key always gets the same lane
+func (wp *WorkerPool) lane(key string) chan task {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return wp.lanes[h.Sum32()%uint32(len(wp.lanes))]
+}
+
+// AddKeyedTask adds the task to the lane of the key. Tasks with the same key
+// run one by one in the order they are added, tasks with different keys
+// may run in parallel. The overflow policy is applied to the lane. It
+// returns ErrNoKeyedLanes if the pool is created without WithKeyedLanes.
+func (wp *WorkerPool) AddKeyedTask(key string, fn func()) error {
+	return wp.AddKeyedTaskContext(context.Background(), key, fn)
+}
+
+// AddKeyedTaskContext is AddKeyedTask which stops waiting for room
+// in the lane when the context is done.
+func (wp *WorkerPool) AddKeyedTaskContext(ctx context.Context, key string, fn func()) error {
+	if len(wp.lanes) == 0 {
+		return ErrNoKeyedLanes
+	}
+
+	return wp.enqueue(ctx, wp.lane(key), task{run: func(context.Context) { fn() }})
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+var (
+	ErrPoolFull           = errors.New("worker pool is full")
+	ErrInvalidWorkersSize = errors.New("workers number must be positive")
+	ErrTaskDropped        = errors.New("task is dropped from the queue")
+	ErrPoolClosed         = errors.New("worker pool is closed")
+)
+
+// PanicError is a panic recovered from a task.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// OverflowPolicy defines what AddTask does when the queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until there is room in the queue
+	OverflowBlock OverflowPolicy = iota
+	// OverflowReject returns ErrPoolFull, the task is not added
+	OverflowReject
+	// OverflowDropOldest drops the oldest queued task to make room
+	OverflowDropOldest
+)
+
+type Option func(*WorkerPool)
+
+// WithQueueSize sets capacity of the task queue,
+// by default it is equal to the number of workers.
+func WithQueueSize(size int) Option {
+	return func(wp *WorkerPool) {
+		wp.queueSize = size
+	}
+}
+
+func WithOverflowPolicy(policy OverflowPolicy) Option {
+	return func(wp *WorkerPool) {
+		wp.overflow = policy
+	}
+}
+
+// WithOnPanic sets the hook called for every panic recovered from a task.
+func WithOnPanic(handler func(*PanicError)) Option {
+	return func(wp *WorkerPool) {
+		wp.onPanic = handler
+	}
+}
+
+// ShutdownReport describes tasks affected by Shutdown.
+type ShutdownReport struct {
+	// NotRun is the number of queued tasks which never ran
+	NotRun int
+	// Interrupted is the number of tasks which were running
+	// when their contexts were canceled by forced shutdown
+	Interrupted int
+	// Pending are the queued plain tasks which never ran, in no particular
+	// order, they may be run later or added to another pool. Tasks added
+	// by Submit are completed with ErrPoolClosed instead.
+	Pending []func(ctx context.Context)
+}
+
+// task is an item of the queue
+type task struct {
+	run func(ctx context.Context)
+	// discard is called instead of run when the task never runs,
+	// it is nil for plain tasks, they are reported by Shutdown
+	discard func(err error)
+}
+
+type WorkerPool struct {
+	tasks chan task
+	wg    sync.WaitGroup
+
+	onPanic func(*PanicError)
+
+	queueSize int
+	// lanes are serial queues of keyed tasks, each one has a dedicated
+	// worker, there are no lanes unless WithKeyedLanes is set
+	lanes     []chan task
+	lanesSize int
+	overflow  OverflowPolicy
+	dropped   atomic.Int64
+
+	weights []int
+	limit   float64
+	burst   int
+	clock   Clock
+	// limiter is nil unless rate limit is set
+	limiter *tokenBucket
+	// admission is nil unless priority lanes or rate limit are set
+	admission *admission
+	// dispatched is closed when the dispatcher stops
+	dispatched chan struct{}
+
+	// resizeMu serializes changes of the workers number
+	resizeMu sync.Mutex
+	workers  int
+	// quit stops one idle worker per received value
+	quit chan struct{}
+
+	// ctx is the parent of the tasks contexts, it is canceled by forced shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// closeMu is read locked while a task is being added, so
+	// no tasks are added after Shutdown write locks it
+	closeMu sync.RWMutex
+	// closing is closed when Shutdown starts, it unblocks adding tasks
+	closing chan struct{}
+	// sealed is closed when no more tasks can be added
+	sealed chan struct{}
+	// drain is closed when no more tasks can be queued, workers
+	// run the rest of the queue and stop
+	drain chan struct{}
+	// abort is closed by forced shutdown, workers stop taking tasks
+	abort chan struct{}
+
+	running     atomic.Int64
+	notRun      atomic.Int64
+	interrupted atomic.Int64
+
+	pendingMu sync.Mutex
+	pending   []func(ctx context.Context)
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+func NewWorkerPool(workersNumber int, options ...Option) *WorkerPool {
+	wp := &WorkerPool{
+		queueSize:  workersNumber,
+		quit:       make(chan struct{}),
+		clock:      realClock{},
+		dispatched: make(chan struct{}),
+		closing:    make(chan struct{}),
+		sealed:     make(chan struct{}),
+		drain:      make(chan struct{}),
+		abort:      make(chan struct{}),
+	}
+	wp.ctx, wp.cancel = context.WithCancel(context.Background())
+
+	for _, option := range options {
+		option(wp)
+	}
+
+	wp.tasks = make(chan task, wp.queueSize)
+	if wp.limit > 0 {
+		wp.limiter = newTokenBucket(wp.clock, wp.limit, wp.burst)
+	}
+	wp.startWorkers(workersNumber)
+	wp.startLanes()
+	wp.startDispatcher()
+
+	return wp
+}
+
+func (wp *WorkerPool) startWorkers(n int) {
+	wp.workers += n
+	wp.wg.Add(n)
+
+	// run workers
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wp.wg.Done()
+			for {
+				select {
+				case <-wp.quit:
+					return
+				case t := <-wp.tasks:
+					wp.runTask(t)
+				case <-wp.drain:
+					wp.drainTasks(wp.tasks)
+					return
+				}
+			}
+		}()
+	}
+}
+
+// drainTasks runs queued tasks until the queue is empty
+// or the shutdown is forced
+func (wp *WorkerPool) drainTasks(queue chan task) {
+	for {
+		select {
+		case <-wp.abort:
+			return
+		default:
+		}
+
+		select {
+		case t := <-queue:
+			wp.runTask(t)
+		default:
+			return
+		}
+	}
+}
+
+// runTask runs the task with its own context, a panic is
+// recovered so it doesn't kill the worker
+func (wp *WorkerPool) runTask(t task) {
+	select {
+	case <-wp.abort:
+		wp.discard(t)
+		return
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(wp.ctx)
+	wp.running.Add(1)
+	defer func() {
+		wp.running.Add(-1)
+		cancel()
+		if r := recover(); r != nil {
+			wp.panicked(r)
+		}
+	}()
+
+	t.run(ctx)
+}
+
+// discard reports the task which never runs because of shutdown
+func (wp *WorkerPool) discard(t task) {
+	wp.notRun.Add(1)
+	if t.discard != nil {
+		t.discard(ErrPoolClosed)
+		return
+	}
+
+	wp.pendingMu.Lock()
+	wp.pending = append(wp.pending, t.run)
+	wp.pendingMu.Unlock()
+}
+
+// discardQueue discards the tasks left in the queue
+func (wp *WorkerPool) discardQueue(queue chan task) {
+	for {
+		select {
+		case t := <-queue:
+			wp.discard(t)
+		default:
+			return
+		}
+	}
+}
+
+// panicked wraps the recovered value into PanicError and reports it to
+// the OnPanic hook, it must be called from the deferred function.
+func (wp *WorkerPool) panicked(r any) *PanicError {
+	err := &PanicError{Value: r, Stack: debug.Stack()}
+	if wp.onPanic != nil {
+		wp.onPanic(err)
+	}
+	return err
+}
+
+// AddTask adds the task to the queue, when the queue is full
+// the overflow policy decides what happens.
+func (wp *WorkerPool) AddTask(fn func()) error {
+	return wp.AddTaskContext(context.Background(), fn)
+}
+
+// AddTaskContext is AddTask which stops waiting for room in the queue
+// when the context is done, it matters only for OverflowBlock policy.
+func (wp *WorkerPool) AddTaskContext(ctx context.Context, fn func()) error {
+	return wp.add(ctx, task{run: func(context.Context) { fn() }})
+}
+
+// AddTaskFunc is AddTask for the task which takes its own context,
+// the context is canceled by forced Shutdown.
+func (wp *WorkerPool) AddTaskFunc(fn func(ctx context.Context)) error {
+	return wp.add(context.Background(), task{run: fn})
+}
+
+// enqueue adds the task to the queue according to the overflow policy
+func (wp *WorkerPool) enqueue(ctx context.Context, queue chan task, t task) error {
+	wp.closeMu.RLock()
+	defer wp.closeMu.RUnlock()
+
+	select {
+	case <-wp.closing:
+		return ErrPoolClosed
+	default:
+	}
+
+	switch wp.overflow {
+	case OverflowReject:
+		select {
+		case queue <- t:
+			return nil
+		default:
+			return ErrPoolFull
+		}
+	case OverflowDropOldest:
+		for {
+			select {
+			case queue <- t:
+				return nil
+			default:
+			}
+
+			// workers may take the oldest task first, then there is room anyway
+			select {
+			case oldest := <-queue:
+				wp.dropped.Add(1)
+				if oldest.discard != nil {
+					oldest.discard(ErrTaskDropped)
+				}
+			default:
+			}
+		}
+	default:
+		select {
+		case queue <- t:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wp.closing:
+			return ErrPoolClosed
+		}
+	}
+}
+
+// Dropped returns the number of tasks dropped by OverflowDropOldest policy.
+func (wp *WorkerPool) Dropped() int {
+	return int(wp.dropped.Load())
+}
+
+// Workers returns the current number of workers.
+func (wp *WorkerPool) Workers() int {
+	wp.resizeMu.Lock()
+	defer wp.resizeMu.Unlock()
+
+	return wp.workers
+}
+
+// Resize changes the number of workers. New workers start right away.
+// Removed workers stop after their current tasks, Resize doesn't wait
+// for them. Queued and running tasks are never lost.
+func (wp *WorkerPool) Resize(workersNumber int) error {
+	if workersNumber < 1 {
+		return ErrInvalidWorkersSize
+	}
+
+	wp.resizeMu.Lock()
+	defer wp.resizeMu.Unlock()
+
+	select {
+	case <-wp.closing:
+		return ErrPoolClosed
+	default:
+	}
+
+	if workersNumber > wp.workers {
+		wp.startWorkers(workersNumber - wp.workers)
+		return nil
+	}
+
+	// busy workers may take long, so the first idle one quits later,
+	// after shutdown starts the workers stop anyway
+	for ; wp.workers > workersNumber; wp.workers-- {
+		go func() {
+			select {
+			case wp.quit <- struct{}{}:
+			case <-wp.drain:
+			}
+		}()
+	}
+
+	return nil
+}
+
+// Shutdown stops accepting tasks and waits until the queued tasks are done.
+// When the context is done first, the running tasks contexts are canceled,
+// the rest of the queue never runs and the context error is returned right
+// away, tasks ignoring their contexts may still be running after that.
+// Repeated calls return the result of the first one.
+func (wp *WorkerPool) Shutdown(ctx context.Context) (ShutdownReport, error) {
+	wp.shutdownOnce.Do(func() {
+		wp.resizeMu.Lock()
+		close(wp.closing)
+		wp.resizeMu.Unlock()
+
+		// wait for tasks being added
+		wp.closeMu.Lock()
+		close(wp.sealed)
+		wp.closeMu.Unlock()
+
+		stopped := make(chan struct{})
+		go func() {
+			// the dispatcher hands the rest of the lanes to workers first
+			<-wp.dispatched
+			close(wp.drain)
+			wp.wg.Wait()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			wp.shutdownErr = ctx.Err()
+			close(wp.abort)
+			wp.interrupted.Store(wp.running.Load())
+			// the dispatcher stops right away, busy workers discard
+			// the task they take next
+			<-wp.dispatched
+		}
+		wp.cancel()
+
+		wp.discardQueue(wp.tasks)
+		for _, lane := range wp.lanes {
+			wp.discardQueue(lane)
+		}
+		if wp.admission != nil {
+			for _, lane := range wp.admission.lanes {
+				wp.discardQueue(lane)
+			}
+		}
+	})
+
+	return wp.Report(), wp.shutdownErr
+}
+
+// Report returns tasks affected by Shutdown, it is complete only after
+// Shutdown returns. After forced shutdown a task taken by a worker at
+// that moment may be reported a bit later.
+func (wp *WorkerPool) Report() ShutdownReport {
+	wp.pendingMu.Lock()
+	defer wp.pendingMu.Unlock()
+
+	return ShutdownReport{
+		NotRun:      int(wp.notRun.Load()),
+		Interrupted: int(wp.interrupted.Load()),
+		Pending:     slices.Clone(wp.pending),
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError is a panic recovered from a goroutine of the group.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("goroutine panicked: %v", e.Value)
+}
+
+// Unwrap returns the panic value if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+type Option func(*Group)
+
+// WithCollectErrors makes Wait return all errors joined by errors.Join
+// in the order they happened. An error doesn't cancel the context
+// in this mode, so the other goroutines run to the end.
+func WithCollectErrors() Option {
+	return func(g *Group) {
+		g.collect = true
+	}
+}
+
+// Group is a collection of goroutines working on subtasks of the same
+// task. By default the first error cancels the context of the group.
+type Group struct {
+	cancel context.CancelCauseFunc
+	ctx    context.Context
+	wg     sync.WaitGroup
+
+	// sem limits the number of active goroutines, nil means no limit
+	sem chan struct{}
+
+	collect bool
+	mu      sync.Mutex
+	errs    []error
+}
+
+func NewErrGroup(ctx context.Context, options ...Option) (*Group, context.Context) {
+	newCtx, cancel := context.WithCancelCause(ctx)
+	g := &Group{
+		cancel: cancel,
+		ctx:    newCtx,
+	}
+
+	for _, option := range options {
+		option(g)
+	}
+
+	return g, newCtx
+}
+
+// SetLimit limits the number of active goroutines to n, a negative
+// value removes the limit. It must not be called while goroutines
+// of the group are active.
+func (g *Group) SetLimit(n int) {
+	if n < 0 {
+		g.sem = nil
+		return
+	}
+
+	if len(g.sem) != 0 {
+		panic(fmt.Errorf("errgroup: modify limit while %d goroutines are active", len(g.sem)))
+	}
+
+	g.sem = make(chan struct{}, n)
+}
+
+// Go runs the action in a new goroutine, it blocks
+// until the goroutine can be added without exceeding the limit.
+func (g *Group) Go(action func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+
+	g.run(action)
+}
+
+// TryGo runs the action in a new goroutine only if the
+// limit is not exceeded, it reports whether it is run.
+func (g *Group) TryGo(action func() error) bool {
+	if g.sem != nil {
+		select {
+		case g.sem <- struct{}{}:
+		default:
+			return false
+		}
+	}
+
+	g.run(action)
+	return true
+}
+
+func (g *Group) run(action func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.done()
+
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+			if err != nil {
+				g.fail(err)
+			}
+		}()
+
+		err = action()
+	}()
+}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	g.wg.Done()
+}
+
+func (g *Group) fail(err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.errs = append(g.errs, err)
+	if !g.collect && len(g.errs) == 1 {
+		g.cancel(err)
+	}
+}
+
+// Wait waits for all goroutines and cancels the context of the group.
+// It returns the first error or all errors joined with WithCollectErrors.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var err error
+	switch {
+	case len(g.errs) == 0:
+	case g.collect:
+		err = errors.Join(g.errs...)
+	default:
+		err = g.errs[0]
+	}
+
+	g.cancel(err)
+	return err
+}
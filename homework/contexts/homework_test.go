@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"errors"
-	"sync"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -11,39 +11,7 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-type Group struct {
-	cancel context.CancelCauseFunc
-	ctx    context.Context
-	wg     sync.WaitGroup
-}
-
-func NewErrGroup(ctx context.Context) (*Group, context.Context) {
-	newCtx, cancel := context.WithCancelCause(ctx)
-	g := &Group{
-		cancel: cancel,
-		ctx:    newCtx,
-	}
-
-	return g, newCtx
-}
-
-func (g *Group) Go(action func() error) {
-	g.wg.Add(1)
-	go func() {
-		defer g.wg.Done()
-		err := action()
-		if err != nil {
-			g.cancel(err)
-		}
-	}()
-}
-
-func (g *Group) Wait() (err error) {
-	g.wg.Wait()
-	return context.Cause(g.ctx)
-}
-
-// go test -v homework_test.go
+// go test -v .
 
 func TestErrGroupWithoutError(t *testing.T) {
 	var counter atomic.Int32
@@ -89,3 +57,193 @@ func TestErrGroupWithError(t *testing.T) {
 	assert.Equal(t, int32(0), counter.Load())
 	assert.Error(t, err)
 }
+
+func TestErrGroupLimit(t *testing.T) {
+	t.Run("Go Waits For Room", func(t *testing.T) {
+		group, _ := NewErrGroup(context.Background())
+		group.SetLimit(2)
+
+		var (
+			running    atomic.Int32
+			maxRunning atomic.Int32
+		)
+		for i := 0; i < 10; i++ {
+			group.Go(func() error {
+				n := running.Add(1)
+				for {
+					current := maxRunning.Load()
+					if n <= current || maxRunning.CompareAndSwap(current, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				running.Add(-1)
+				return nil
+			})
+		}
+
+		assert.NoError(t, group.Wait())
+		assert.Equal(t, int32(2), maxRunning.Load())
+	})
+
+	t.Run("TryGo", func(t *testing.T) {
+		group, _ := NewErrGroup(context.Background())
+		group.SetLimit(1)
+
+		release := make(chan struct{})
+		assert.True(t, group.TryGo(func() error {
+			<-release
+			return nil
+		}))
+		assert.False(t, group.TryGo(func() error { return nil }))
+		assert.Panics(t, func() { group.SetLimit(2) })
+
+		close(release)
+		assert.NoError(t, group.Wait())
+
+		assert.True(t, group.TryGo(func() error { return nil }))
+		assert.NoError(t, group.Wait())
+	})
+}
+
+func TestErrGroupPanic(t *testing.T) {
+	group, ctx := NewErrGroup(context.Background())
+
+	errBoom := errors.New("boom")
+	group.Go(func() error {
+		panic(errBoom)
+	})
+
+	err := group.Wait()
+
+	var panicErr *PanicError
+	assert.ErrorAs(t, err, &panicErr)
+	assert.ErrorIs(t, err, errBoom)
+	assert.Contains(t, string(panicErr.Stack), "TestErrGroupPanic")
+	assert.ErrorIs(t, context.Cause(ctx), errBoom)
+}
+
+func TestErrGroupCollectErrors(t *testing.T) {
+	err1 := errors.New("error 1")
+	err2 := errors.New("error 2")
+
+	t.Run("First Error By Default", func(t *testing.T) {
+		group, _ := NewErrGroup(context.Background())
+		group.SetLimit(1)
+
+		group.Go(func() error { return err1 })
+		group.Go(func() error { return err2 })
+
+		assert.Equal(t, err1, group.Wait())
+	})
+
+	t.Run("All Errors", func(t *testing.T) {
+		group, ctx := NewErrGroup(context.Background(), WithCollectErrors())
+		group.SetLimit(1)
+
+		var counter atomic.Int32
+		group.Go(func() error { return err1 })
+		group.Go(func() error {
+			// an error doesn't cancel the context
+			assert.NoError(t, ctx.Err())
+			counter.Add(1)
+			return nil
+		})
+		group.Go(func() error { panic("boom") })
+		group.Go(func() error { return err2 })
+
+		err := group.Wait()
+		assert.ErrorIs(t, err, err1)
+		assert.ErrorIs(t, err, err2)
+		assert.ErrorAs(t, err, ptr[*PanicError](nil))
+		assert.Len(t, err.(interface{ Unwrap() []error }).Unwrap(), 3)
+		assert.Equal(t, int32(1), counter.Load())
+
+		// the context is canceled after Wait anyway
+		assert.Error(t, ctx.Err())
+	})
+}
+
+func ptr[T any](t T) *T {
+	return &t
+}
+
+func TestResultGroup(t *testing.T) {
+	t.Run("Results In Input Order", func(t *testing.T) {
+		group, _ := NewResultGroup[string](context.Background())
+
+		inputs := []int{5, 3, 1, 4, 2}
+		for i, n := range inputs {
+			group.Go(i, func(ctx context.Context) (string, error) {
+				time.Sleep(time.Duration(n) * time.Millisecond)
+				return strings.Repeat("*", n), nil
+			})
+		}
+
+		results, err := group.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"*****", "***", "*", "****", "**"}, results)
+	})
+
+	t.Run("First Error Cancels Siblings", func(t *testing.T) {
+		group, ctx := NewResultGroup[int](context.Background())
+
+		errLookup := errors.New("lookup failed")
+		var canceled atomic.Int32
+		for i := 0; i < 5; i++ {
+			group.Go(i, func(ctx context.Context) (int, error) {
+				select {
+				case <-ctx.Done():
+					canceled.Add(1)
+					return 0, ctx.Err()
+				case <-time.After(time.Second):
+					return i, nil
+				}
+			})
+		}
+		group.Go(5, func(ctx context.Context) (int, error) {
+			return 0, errLookup
+		})
+
+		start := time.Now()
+		results, err := group.Wait()
+		assert.Less(t, time.Since(start), time.Second)
+
+		assert.Equal(t, errLookup, err)
+		assert.ErrorIs(t, context.Cause(ctx), errLookup)
+		assert.Equal(t, int32(5), canceled.Load())
+		assert.Len(t, results, 6)
+		for _, result := range results {
+			assert.Zero(t, result)
+		}
+	})
+
+	t.Run("Failed Indexes Collecting Errors", func(t *testing.T) {
+		group, _ := NewResultGroup[int](context.Background(), WithCollectErrors())
+
+		errLookup := errors.New("lookup failed")
+		group.Go(0, func(ctx context.Context) (int, error) { return 1, nil })
+		group.Go(1, func(ctx context.Context) (int, error) { return 0, errLookup })
+		group.Go(2, func(ctx context.Context) (int, error) { return 0, errLookup })
+
+		results, err := group.Wait()
+		assert.ErrorIs(t, err, errLookup)
+		assert.Equal(t, []int{1, 0, 0}, results)
+	})
+
+	t.Run("Sparse Indexes", func(t *testing.T) {
+		group, _ := NewResultGroup[int](context.Background())
+		group.SetLimit(1)
+
+		group.Go(3, func(ctx context.Context) (int, error) { return 3, nil })
+		group.Go(1, func(ctx context.Context) (int, error) { return 1, nil })
+
+		results, err := group.Wait()
+		assert.NoError(t, err)
+		assert.Equal(t, []int{0, 1, 0, 3}, results)
+
+		assert.Panics(t, func() {
+			group.Go(-1, func(ctx context.Context) (int, error) { return 0, nil })
+		})
+	})
+}
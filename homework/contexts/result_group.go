@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ResultGroup is Group which collects results of the goroutines
+// by their indexes. The first error cancels the context passed to
+// the actions, so the other goroutines can stop early.
+type ResultGroup[T any] struct {
+	group *Group
+	ctx   context.Context
+
+	mu      sync.Mutex
+	results []T
+}
+
+func NewResultGroup[T any](ctx context.Context, options ...Option) (*ResultGroup[T], context.Context) {
+	group, newCtx := NewErrGroup(ctx, options...)
+	g := &ResultGroup[T]{
+		group: group,
+		ctx:   newCtx,
+	}
+
+	return g, newCtx
+}
+
+// SetLimit limits the number of active goroutines, see Group.SetLimit.
+func (g *ResultGroup[T]) SetLimit(n int) {
+	g.group.SetLimit(n)
+}
+
+// Go runs the action in a new goroutine, its result is stored at the
+// index i of the slice returned by Wait, which length is the maximum
+// index passed to Go plus one.
+func (g *ResultGroup[T]) Go(i int, action func(ctx context.Context) (T, error)) {
+	if i < 0 {
+		panic(fmt.Errorf("errgroup: negative result index %d", i))
+	}
+
+	// the slot is taken before the goroutine runs,
+	// so results have room for failed goroutines too
+	g.mu.Lock()
+	if i >= len(g.results) {
+		g.results = append(g.results, make([]T, i+1-len(g.results))...)
+	}
+	g.mu.Unlock()
+
+	g.group.Go(func() error {
+		result, err := action(g.ctx)
+		if err != nil {
+			return err
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.results[i] = result
+
+		return nil
+	})
+}
+
+// Wait waits for all goroutines and returns their results in the order
+// of indexes with the error of Group.Wait. The results of failed
+// goroutines and of indexes never passed to Go are zero values.
+func (g *ResultGroup[T]) Wait() ([]T, error) {
+	err := g.group.Wait()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.results, err
+}
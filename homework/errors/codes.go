@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"strconv"
+)
+
+// Code classifies errors, the values match gRPC status codes.
+type Code int
+
+const (
+	CodeOK Code = iota
+	CodeCanceled
+	CodeUnknown
+	CodeInvalidArgument
+	CodeDeadlineExceeded
+	CodeNotFound
+	CodeAlreadyExists
+	CodePermissionDenied
+	CodeResourceExhausted
+	CodeFailedPrecondition
+	CodeAborted
+	CodeOutOfRange
+	CodeUnimplemented
+	CodeInternal
+	CodeUnavailable
+	CodeDataLoss
+	CodeUnauthenticated
+)
+
+var codeNames = [...]string{
+	CodeOK:                 "OK",
+	CodeCanceled:           "Canceled",
+	CodeUnknown:            "Unknown",
+	CodeInvalidArgument:    "InvalidArgument",
+	CodeDeadlineExceeded:   "DeadlineExceeded",
+	CodeNotFound:           "NotFound",
+	CodeAlreadyExists:      "AlreadyExists",
+	CodePermissionDenied:   "PermissionDenied",
+	CodeResourceExhausted:  "ResourceExhausted",
+	CodeFailedPrecondition: "FailedPrecondition",
+	CodeAborted:            "Aborted",
+	CodeOutOfRange:         "OutOfRange",
+	CodeUnimplemented:      "Unimplemented",
+	CodeInternal:           "Internal",
+	CodeUnavailable:        "Unavailable",
+	CodeDataLoss:           "DataLoss",
+	CodeUnauthenticated:    "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if c >= 0 && int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.Itoa(int(c)) + ")"
+}
+
+// CodedError is an error with the code, it may wrap the cause.
+type CodedError struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+// NewCoded returns the error with the code and the message.
+func NewCoded(code Code, message string) error {
+	return &CodedError{Code: code, Message: message}
+}
+
+// WithCode adds the code to the error, it returns nil for nil error.
+func WithCode(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+func (e *CodedError) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Message
+	case e.Message == "":
+		return e.Err.Error()
+	default:
+		return e.Message + ": " + e.Err.Error()
+	}
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// CodeOf returns the code of the first CodedError found by errors.As,
+// it walks wrap chains and MultiError trees depth first. Context errors
+// without a code are CodeCanceled and CodeDeadlineExceeded, the other
+// errors are CodeUnknown. The code of nil error is CodeOK.
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+
+	var codedErr *CodedError
+	switch {
+	case errors.As(err, &codedErr):
+		return codedErr.Code
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeDeadlineExceeded
+	default:
+		return CodeUnknown
+	}
+}
+
+// StatusMapping maps codes to HTTP statuses, the codes
+// missing in the mapping are mapped to 500.
+type StatusMapping map[Code]int
+
+// DefaultHTTPStatus is the mapping used by gRPC gateways.
+var DefaultHTTPStatus = StatusMapping{
+	CodeOK:                 http.StatusOK,
+	CodeCanceled:           499,
+	CodeUnknown:            http.StatusInternalServerError,
+	CodeInvalidArgument:    http.StatusBadRequest,
+	CodeDeadlineExceeded:   http.StatusGatewayTimeout,
+	CodeNotFound:           http.StatusNotFound,
+	CodeAlreadyExists:      http.StatusConflict,
+	CodePermissionDenied:   http.StatusForbidden,
+	CodeResourceExhausted:  http.StatusTooManyRequests,
+	CodeFailedPrecondition: http.StatusBadRequest,
+	CodeAborted:            http.StatusConflict,
+	CodeOutOfRange:         http.StatusBadRequest,
+	CodeUnimplemented:      http.StatusNotImplemented,
+	CodeInternal:           http.StatusInternalServerError,
+	CodeUnavailable:        http.StatusServiceUnavailable,
+	CodeDataLoss:           http.StatusInternalServerError,
+	CodeUnauthenticated:    http.StatusUnauthorized,
+}
+
+// With returns the copy of the mapping with the code mapped to the status.
+func (m StatusMapping) With(code Code, status int) StatusMapping {
+	mapping := maps.Clone(m)
+	if mapping == nil {
+		mapping = make(StatusMapping)
+	}
+	mapping[code] = status
+	return mapping
+}
+
+// Status returns the HTTP status of the error code, see CodeOf.
+func (m StatusMapping) Status(err error) int {
+	if status, ok := m[CodeOf(err)]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// HTTPStatus returns the HTTP status of the error by DefaultHTTPStatus.
+func HTTPStatus(err error) int {
+	return DefaultHTTPStatus.Status(err)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// OmittedError is the summary of errors not stored by Collector.
+type OmittedError struct {
+	Count int
+}
+
+func (e *OmittedError) Error() string {
+	return "and " + strconv.Itoa(e.Count) + " more errors"
+}
+
+type CollectorOption func(*Collector)
+
+// WithLimit limits the number of stored errors, the rest
+// are only counted by the OmittedError summary.
+func WithLimit(n int) CollectorOption {
+	return func(c *Collector) {
+		c.limit = n
+	}
+}
+
+// WithDedup skips an error equal to a stored one by errors.Is in
+// any direction. Omitted errors are not deduplicated.
+func WithDedup() CollectorOption {
+	return func(c *Collector) {
+		c.dedup = true
+	}
+}
+
+// Collector accumulates errors from many goroutines into MultiError.
+// The zero value collects all errors without deduplication.
+type Collector struct {
+	mu      sync.Mutex
+	errs    []error
+	omitted int
+
+	limit int
+	dedup bool
+}
+
+func NewCollector(options ...CollectorOption) *Collector {
+	c := &Collector{}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Add stores the error, nil is ignored.
+func (c *Collector) Add(err error) {
+	if err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dedup && c.contains(err) {
+		return
+	}
+
+	if c.limit > 0 && len(c.errs) >= c.limit {
+		c.omitted++
+		return
+	}
+
+	c.errs = append(c.errs, err)
+}
+
+func (c *Collector) contains(err error) bool {
+	for _, stored := range c.errs {
+		if errors.Is(err, stored) || errors.Is(stored, err) {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the collected errors as MultiError followed by the
+// OmittedError summary if some errors are omitted, nil if there are
+// no errors. Errors added later don't change the returned one.
+func (c *Collector) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.errs) == 0 {
+		return nil
+	}
+
+	mErr := Append(nil, c.errs...)
+	if c.omitted > 0 {
+		mErr = Append(mErr, &OmittedError{Count: c.omitted})
+	}
+	return mErr
+}
@@ -1,116 +1,31 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
-	"strconv"
+	"fmt"
+	"math"
+	"net/http"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
-)
-
-// go test -v homework_test.go
-
-type MultiError struct {
-	errors []error
-}
-
-func (e *MultiError) Error() string {
-	n := len(e.errors)
-
-	if n == 0 {
-		return ""
-	}
-
-	if n == 1 {
-		return e.errors[0].Error()
-	}
-
-	sb := strings.Builder{}
-	sb.WriteString(strconv.FormatInt(int64(n), 10))
-	sb.WriteString(" errors occured:\n")
-	for _, err := range e.errors {
-		sb.WriteString("\t* ")
-		sb.WriteString(err.Error())
-	}
-
-	sb.WriteByte('\n')
-
-	return sb.String()
-}
-
-func (e *MultiError) Is(target error) bool {
-	if e == nil {
-		return e == target
-	}
-
-	for _, err := range e.errors {
-		if errors.Is(err, target) {
-			return true
-		}
-	}
-
-	return false
-}
-
-func (e *MultiError) As(target any) bool {
-	if e == nil {
-		return e == target
-	}
-
-	for _, err := range e.errors {
-		if errors.As(err, target) {
-			return true
-		}
-	}
-
-	return false
-}
 
-func (e *MultiError) Unwrap() error {
-	if e == nil || len(e.errors) == 0 {
-		return nil
-	}
-
-	if len(e.errors) == 1 {
-		return e.errors[0]
-	}
-
-	return &MultiError{
-		errors: e.errors[1:],
-	}
-}
-
-func Append(err error, errs ...error) *MultiError {
-	if err == nil && len(errs) == 0 {
-		return nil
-	}
-
-	if err == nil {
-		return &MultiError{
-			errors: errs,
-		}
-	}
-
-	mErr, ok := err.(*MultiError)
-	if !ok {
-		mErr = &MultiError{
-			errors: make([]error, 0, len(errs)+1),
-		}
-		mErr.errors = append(mErr.errors, err)
-	}
+	"golang_course/internal/clock"
+	"golang_course/internal/stackerr"
+)
 
-	mErr.errors = append(mErr.errors, errs...)
-
-	return mErr
-}
+// go test -v .
 
 func TestMultiError(t *testing.T) {
 	var err error
 	err = Append(err, errors.New("error 1"))
 	err = Append(err, errors.New("error 2"))
 
-	expectedMessage := "2 errors occured:\n\t* error 1\t* error 2\n"
+	expectedMessage := "2 errors occured:\n\t* error 1\n\t* error 2\n"
 	assert.EqualError(t, err, expectedMessage)
 }
 
@@ -118,24 +33,16 @@ func TestMultiErrorUnwrap(t *testing.T) {
 	err1 := errors.New("err1")
 	err2 := errors.New("err2")
 	err3 := errors.New("err3")
-	err := Append(nil, err1, err2, err3)
-
-	unwrappedOnce := errors.Unwrap(err)
-	assert.False(t, errors.Is(unwrappedOnce, err1))
-	assert.True(t, errors.Is(unwrappedOnce, err2))
-	assert.True(t, errors.Is(unwrappedOnce, err3))
-
-	unwrappedTwice := errors.Unwrap(unwrappedOnce)
-	assert.False(t, errors.Is(unwrappedTwice, err1))
-	assert.False(t, errors.Is(unwrappedTwice, err2))
-	assert.True(t, errors.Is(unwrappedTwice, err3))
+	nested := Append(nil, err2, err3)
+	err := Append(nil, err1, nested)
 
-	unwrappedThrice := errors.Unwrap(unwrappedTwice)
-	assert.False(t, errors.Is(unwrappedThrice, err1))
-	assert.False(t, errors.Is(unwrappedThrice, err2))
-	assert.True(t, errors.Is(unwrappedThrice, err3))
+	assert.Nil(t, errors.Unwrap(err))
+	assert.Equal(t, []error{err1, nested}, err.Unwrap())
+	assert.Equal(t, []error{err2, err3}, nested.Unwrap())
 
-	assert.Nil(t, errors.Unwrap(unwrappedThrice))
+	// the whole tree is walked
+	assert.True(t, errors.Is(err, err3))
+	assert.True(t, errors.Is(fmt.Errorf("wrapped: %w", err), err2))
 }
 
 func TestMultiErrorIs(t *testing.T) {
@@ -181,3 +88,431 @@ func TestMultiErrorAs(t *testing.T) {
 	assert.True(t, errors.As(err, ptr(error1(""))))
 	assert.False(t, errors.As(err, ptr(error3(""))))
 }
+
+var (
+	errRequired = errors.New("is required")
+	errTooLong  = errors.New("is too long")
+)
+
+// validationError builds the tree of a request validation
+func validationError() *MultiError {
+	address := Append(nil,
+		WithKey("zip", errRequired),
+		WithKey("city", fmt.Errorf("%q %w", "Saint Petersburg, Russia", errTooLong)),
+	)
+
+	return Append(nil,
+		WithKey("name", errRequired),
+		WithKey("address", address),
+		error1("internal"),
+	)
+}
+
+func TestMultiErrorTree(t *testing.T) {
+	err := validationError()
+
+	t.Run("Text", func(t *testing.T) {
+		expected := "3 errors occured:\n" +
+			"\t* name: is required\n" +
+			"\t* address: 2 errors occured:\n" +
+			"\t\t* zip: is required\n" +
+			"\t\t* city: \"Saint Petersburg, Russia\" is too long\n" +
+			"\t* internal\n"
+		assert.EqualError(t, err, expected)
+	})
+
+	t.Run("Is And As In Subtree", func(t *testing.T) {
+		assert.ErrorIs(t, err, errTooLong)
+		assert.ErrorAs(t, err, ptr(error1("")))
+		assert.ErrorAs(t, err, ptr[*KeyError](nil))
+	})
+
+	t.Run("Leaves", func(t *testing.T) {
+		var messages []string
+		for _, leaf := range err.Errors() {
+			messages = append(messages, leaf.Error())
+		}
+
+		assert.Equal(t, []string{
+			"name: is required",
+			"address.zip: is required",
+			`address.city: "Saint Petersburg, Russia" is too long`,
+			"internal",
+		}, messages)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		data, jsonErr := json.Marshal(err)
+		assert.NoError(t, jsonErr)
+
+		expected := `{"errors":[
+			{"key":"name","message":"is required"},
+			{"key":"address","errors":[
+				{"key":"zip","message":"is required"},
+				{"key":"city","message":"\"Saint Petersburg, Russia\" is too long"}
+			]},
+			{"message":"internal"}
+		]}`
+		assert.JSONEq(t, expected, string(data))
+	})
+
+	t.Run("Group By", func(t *testing.T) {
+		groups := err.GroupBy(errRequired, errTooLong)
+
+		assert.Len(t, groups, 3)
+		assert.Len(t, groups["is required"], 2)
+		assert.EqualError(t, groups["is required"][1], "address.zip: is required")
+		assert.Len(t, groups["is too long"], 1)
+		assert.Equal(t, []error{error1("internal")}, groups["main.error1"])
+	})
+}
+
+func TestAppend(t *testing.T) {
+	err1 := errors.New("err1")
+	err2 := errors.New("err2")
+
+	assert.Nil(t, Append(nil))
+	assert.Nil(t, Append(nil, nil, nil))
+	assert.Equal(t, 1, Append(nil, nil, err1).Len())
+
+	var empty *MultiError
+	assert.Equal(t, []error{err1}, Append(empty, err1).Unwrap())
+
+	// nested tree is kept as a subtree
+	err := Append(err1, Append(nil, err1, err2))
+	assert.Equal(t, 2, err.Len())
+
+	// MultiError is extended in place
+	same := Append(err, err2)
+	assert.Same(t, err, same)
+	assert.Equal(t, 3, err.Len())
+}
+
+func TestCollector(t *testing.T) {
+	t.Run("Concurrent Add", func(t *testing.T) {
+		var collector Collector
+		assert.NoError(t, collector.Err())
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				collector.Add(fmt.Errorf("error %d", i))
+				collector.Add(nil)
+			}()
+		}
+		wg.Wait()
+
+		var mErr *MultiError
+		assert.ErrorAs(t, collector.Err(), &mErr)
+		assert.Equal(t, 100, mErr.Len())
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		collector := NewCollector(WithLimit(2))
+		for i := 1; i <= 5; i++ {
+			collector.Add(fmt.Errorf("error %d", i))
+		}
+
+		err := collector.Err()
+		assert.EqualError(t, err, "3 errors occured:\n\t* error 1\n\t* error 2\n\t* and 3 more errors\n")
+		assert.ErrorAs(t, err, ptr[*OmittedError](nil))
+
+		// returned error doesn't change
+		collector.Add(errors.New("error 6"))
+		assert.Contains(t, err.Error(), "and 3 more errors")
+		assert.Contains(t, collector.Err().Error(), "and 4 more errors")
+	})
+
+	t.Run("Dedup", func(t *testing.T) {
+		errNotFound := errors.New("not found")
+
+		collector := NewCollector(WithDedup())
+		collector.Add(errNotFound)
+		collector.Add(fmt.Errorf("user: %w", errNotFound))
+		collector.Add(errNotFound)
+		collector.Add(errRequired)
+
+		err := collector.Err()
+		assert.Equal(t, []error{errNotFound, errRequired}, err.(*MultiError).Unwrap())
+
+		// wrapped error goes first, then the sentinel is its duplicate
+		collector = NewCollector(WithDedup())
+		collector.Add(fmt.Errorf("user: %w", errNotFound))
+		collector.Add(errNotFound)
+		assert.EqualError(t, collector.Err(), "user: not found")
+	})
+}
+
+func newStackError() error {
+	return stackerr.WithStack(errRequired)
+}
+
+func TestStackErrorInMultiError(t *testing.T) {
+	err := Append(nil, errTooLong, WithKey("name", newStackError()))
+
+	var stackErr *stackerr.Error
+	assert.ErrorAs(t, err, &stackErr)
+	assert.Equal(t, err.Error(), fmt.Sprintf("%v", err))
+
+	lines := strings.Split(fmt.Sprintf("%+v", err), "\n")
+	assert.Equal(t, "2 errors occured:", lines[0])
+	assert.Equal(t, "\t* is too long", lines[1])
+	assert.Equal(t, "\t* name: is required", lines[2])
+	assert.Regexp(t, `^\t\t\S+\.newStackError$`, lines[3])
+}
+
+func TestCodeOf(t *testing.T) {
+	errNotFound := NewCoded(CodeNotFound, "user not found")
+	errDenied := WithCode(CodePermissionDenied, errors.New("no access"))
+
+	tests := map[string]struct {
+		err    error
+		code   Code
+		status int
+	}{
+		"Nil":            {err: nil, code: CodeOK, status: http.StatusOK},
+		"Plain":          {err: errors.New("plain"), code: CodeUnknown, status: http.StatusInternalServerError},
+		"Coded":          {err: errNotFound, code: CodeNotFound, status: http.StatusNotFound},
+		"Coded Cause":    {err: errDenied, code: CodePermissionDenied, status: http.StatusForbidden},
+		"Wrapped":        {err: fmt.Errorf("get user: %w", errNotFound), code: CodeNotFound, status: http.StatusNotFound},
+		"Wrapped Twice":  {err: fmt.Errorf("api: %w", fmt.Errorf("get user: %w", errDenied)), code: CodePermissionDenied, status: http.StatusForbidden},
+		"With Stack":     {err: stackerr.WithStack(errNotFound), code: CodeNotFound, status: http.StatusNotFound},
+		"Joined":         {err: errors.Join(errors.New("plain"), errDenied), code: CodePermissionDenied, status: http.StatusForbidden},
+		"Joined First":   {err: errors.Join(errNotFound, errDenied), code: CodeNotFound, status: http.StatusNotFound},
+		"Multi Error":    {err: Append(errors.New("plain"), errNotFound), code: CodeNotFound, status: http.StatusNotFound},
+		"Nested":         {err: Append(nil, errRequired, WithKey("address", Append(nil, errTooLong, errDenied))), code: CodePermissionDenied, status: http.StatusForbidden},
+		"Nested Wrapped": {err: fmt.Errorf("validate: %w", Append(nil, WithKey("name", WithCode(CodeInvalidArgument, errRequired)))), code: CodeInvalidArgument, status: http.StatusBadRequest},
+		"Canceled":       {err: fmt.Errorf("query: %w", context.Canceled), code: CodeCanceled, status: 499},
+		"Deadline":       {err: Append(nil, context.DeadlineExceeded), code: CodeDeadlineExceeded, status: http.StatusGatewayTimeout},
+		"Coded Context":  {err: WithCode(CodeUnavailable, context.DeadlineExceeded), code: CodeUnavailable, status: http.StatusServiceUnavailable},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.code, CodeOf(test.err))
+			assert.Equal(t, test.status, HTTPStatus(test.err))
+		})
+	}
+}
+
+func TestCodedError(t *testing.T) {
+	cause := errors.New("connection refused")
+
+	assert.EqualError(t, NewCoded(CodeInternal, "failed"), "failed")
+	assert.EqualError(t, WithCode(CodeUnavailable, cause), "connection refused")
+	assert.EqualError(t, &CodedError{Code: CodeUnavailable, Message: "db", Err: cause}, "db: connection refused")
+	assert.ErrorIs(t, WithCode(CodeUnavailable, cause), cause)
+	assert.Nil(t, WithCode(CodeInternal, nil))
+
+	assert.Equal(t, "NotFound", CodeNotFound.String())
+	assert.Equal(t, "Code(100)", Code(100).String())
+}
+
+func TestStatusMapping(t *testing.T) {
+	mapping := DefaultHTTPStatus.With(CodeNotFound, http.StatusGone)
+
+	err := NewCoded(CodeNotFound, "removed")
+	assert.Equal(t, http.StatusGone, mapping.Status(err))
+	assert.Equal(t, http.StatusNotFound, HTTPStatus(err))
+
+	// missing codes are internal errors
+	assert.Equal(t, http.StatusInternalServerError, StatusMapping{}.Status(err))
+	assert.Equal(t, http.StatusInternalServerError, mapping.Status(NewCoded(Code(100), "custom")))
+	assert.Equal(t, http.StatusTeapot, StatusMapping(nil).With(Code(100), http.StatusTeapot).Status(NewCoded(Code(100), "custom")))
+}
+
+// fakeClock is clock.Fake which advances itself by every delay and records
+// the delays. Retry waits in the calling goroutine, so there is nobody
+// else to call Advance.
+type fakeClock struct {
+	*clock.Fake
+	delays []time.Duration
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{Fake: clock.NewFake()}
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.delays = append(c.delays, d)
+
+	ch := c.Fake.After(d)
+	c.Advance(d)
+	return ch
+}
+
+// failingFunc fails the first n calls with errors "error 1", "error 2" and so on
+func failingFunc(n int, calls *int) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		*calls++
+		if *calls <= n {
+			return fmt.Errorf("error %d", *calls)
+		}
+		return nil
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Exponential Backoff", func(t *testing.T) {
+		clock := newFakeClock()
+		policy := RetryPolicy{
+			InitialDelay: 100 * time.Millisecond,
+			MaxDelay:     time.Second,
+			Clock:        clock,
+		}
+
+		var calls int
+		assert.NoError(t, Retry(ctx, policy, failingFunc(5, &calls)))
+		assert.Equal(t, 6, calls)
+		assert.Equal(t, []time.Duration{
+			100 * time.Millisecond,
+			200 * time.Millisecond,
+			400 * time.Millisecond,
+			800 * time.Millisecond,
+			time.Second,
+		}, clock.delays)
+	})
+
+	t.Run("Jitter", func(t *testing.T) {
+		clock := newFakeClock()
+		policy := RetryPolicy{
+			InitialDelay: 100 * time.Millisecond,
+			Multiplier:   3,
+			Jitter:       0.5,
+			Clock:        clock,
+			Rand:         func() float64 { return 0.5 },
+		}
+
+		var calls int
+		assert.NoError(t, Retry(ctx, policy, failingFunc(2, &calls)))
+		assert.Equal(t, []time.Duration{75 * time.Millisecond, 225 * time.Millisecond}, clock.delays)
+
+		// default random jitter stays in bounds
+		policy.Rand = nil
+		policy.Clock = newFakeClock()
+		policy.InitialDelay = time.Second
+		calls = 0
+		assert.NoError(t, Retry(ctx, policy, failingFunc(1, &calls)))
+		delay := policy.Clock.(*fakeClock).delays[0]
+		assert.GreaterOrEqual(t, delay, 500*time.Millisecond)
+		assert.LessOrEqual(t, delay, time.Second)
+	})
+
+	t.Run("Large Attempt Number", func(t *testing.T) {
+		policy := RetryPolicy{InitialDelay: 100 * time.Millisecond}
+
+		previous := policy.delay(1)
+		for attempt := 2; attempt <= 2000; attempt++ {
+			delay := policy.delay(attempt)
+			assert.GreaterOrEqual(t, delay, previous, "attempt %d", attempt)
+			previous = delay
+		}
+		assert.Equal(t, time.Duration(math.MaxInt64), previous)
+
+		// zero initial delay is the default one, not a busy loop
+		assert.Equal(t, DefaultRetryPolicy.InitialDelay, RetryPolicy{}.delay(1))
+	})
+
+	t.Run("Max Attempts", func(t *testing.T) {
+		policy := RetryPolicy{MaxAttempts: 3, InitialDelay: time.Second, Clock: newFakeClock()}
+
+		var calls int
+		err := Retry(ctx, policy, failingFunc(10, &calls))
+		assert.Equal(t, 3, calls)
+		assert.EqualError(t, err, "3 errors occured:\n"+
+			"\t* attempt 1: error 1\n"+
+			"\t* attempt 2: error 2\n"+
+			"\t* attempt 3: error 3\n")
+	})
+
+	t.Run("Max Elapsed", func(t *testing.T) {
+		clock := newFakeClock()
+		policy := RetryPolicy{MaxElapsed: 5 * time.Second, InitialDelay: time.Second, Clock: clock}
+
+		var calls int
+		err := Retry(ctx, policy, failingFunc(10, &calls))
+		assert.Error(t, err)
+
+		// the third delay of 4s would end at 7s
+		assert.Equal(t, 3, calls)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, clock.delays)
+	})
+
+	t.Run("Every Attempt Error", func(t *testing.T) {
+		errTimeout := errors.New("timeout")
+		errUnavailable := NewCoded(CodeUnavailable, "unavailable")
+		attemptErrs := []error{errTimeout, errUnavailable, errTooLong}
+
+		var calls int
+		err := Retry(ctx, RetryPolicy{MaxAttempts: 3, Clock: newFakeClock()}, func(ctx context.Context) error {
+			calls++
+			return attemptErrs[calls-1]
+		})
+
+		for _, attemptErr := range attemptErrs {
+			assert.ErrorIs(t, err, attemptErr)
+		}
+		assert.Equal(t, CodeUnavailable, CodeOf(err))
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		errInvalid := errors.New("invalid request")
+
+		var calls int
+		err := Retry(ctx, DefaultRetryPolicy, func(ctx context.Context) error {
+			calls++
+			return fmt.Errorf("call: %w", Permanent(errInvalid))
+		})
+		assert.Equal(t, 1, calls)
+		assert.ErrorIs(t, err, errInvalid)
+		assert.ErrorAs(t, err, ptr[*PermanentError](nil))
+		assert.Nil(t, Permanent(nil))
+
+		// permanent error in MultiError stops retries too
+		calls = 0
+		policy := DefaultRetryPolicy
+		policy.Clock = newFakeClock()
+		err = Retry(ctx, policy, func(ctx context.Context) error {
+			calls++
+			if calls == 1 {
+				return errors.New("temporary")
+			}
+			return Append(errors.New("temporary"), Permanent(errInvalid))
+		})
+		assert.Equal(t, 2, calls)
+		assert.ErrorIs(t, err, errInvalid)
+	})
+
+	t.Run("Context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var calls int
+		err := Retry(ctx, RetryPolicy{Clock: newFakeClock()}, func(ctx context.Context) error {
+			calls++
+			if calls == 2 {
+				cancel()
+			}
+			return errTooLong
+		})
+		assert.Equal(t, 2, calls)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, errTooLong)
+		assert.Equal(t, 3, err.(*MultiError).Len())
+
+		// the delay is interrupted by the context
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err = Retry(ctx, RetryPolicy{InitialDelay: time.Minute}, func(ctx context.Context) error {
+			return errTooLong
+		})
+		assert.Less(t, time.Since(start), time.Second)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
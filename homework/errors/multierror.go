@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MultiError is a tree of errors, a child may be a MultiError itself.
+// Children may be labeled by a key with WithKey.
+type MultiError struct {
+	errors []error
+}
+
+// KeyError labels an error with a key, for example a field name of the
+// validated request. A labeled MultiError makes a labeled subtree.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+// WithKey labels the error with the key, it returns nil for nil error.
+func WithKey(key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &KeyError{Key: key, Err: err}
+}
+
+func (e *KeyError) Error() string {
+	return e.Key + ": " + e.Err.Error()
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+func (e *MultiError) Error() string {
+	n := len(e.errors)
+
+	if n == 0 {
+		return ""
+	}
+
+	if n == 1 {
+		return e.errors[0].Error()
+	}
+
+	sb := strings.Builder{}
+	e.write(&sb, 1, false)
+
+	return sb.String()
+}
+
+// Format prints the message for %s and %v, with %+v every leaf
+// is printed with %+v too, so stack traces are included.
+func (e *MultiError) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('+') && len(e.errors) == 1:
+		_, _ = fmt.Fprintf(s, "%+v", e.errors[0])
+	case verb == 'v' && s.Flag('+'):
+		sb := strings.Builder{}
+		e.write(&sb, 1, true)
+		_, _ = io.WriteString(s, sb.String())
+	case verb == 'q':
+		_, _ = fmt.Fprintf(s, "%q", e.Error())
+	default:
+		_, _ = io.WriteString(s, e.Error())
+	}
+}
+
+// write renders the header and children indented by depth
+// tabs, nested trees are indented one tab deeper
+func (e *MultiError) write(sb *strings.Builder, depth int, verbose bool) {
+	sb.WriteString(strconv.FormatInt(int64(len(e.errors)), 10))
+	sb.WriteString(" errors occured:\n")
+
+	for _, err := range e.errors {
+		sb.WriteString(strings.Repeat("\t", depth))
+		sb.WriteString("* ")
+
+		if keyErr, ok := err.(*KeyError); ok {
+			sb.WriteString(keyErr.Key)
+			sb.WriteString(": ")
+			err = keyErr.Err
+		}
+
+		if mErr, ok := err.(*MultiError); ok && len(mErr.errors) > 1 {
+			mErr.write(sb, depth+1, verbose)
+			continue
+		}
+
+		if verbose {
+			// continuation lines go under the leaf
+			indent := "\n" + strings.Repeat("\t", depth+1)
+			sb.WriteString(strings.ReplaceAll(fmt.Sprintf("%+v", err), "\n", indent))
+		} else {
+			sb.WriteString(err.Error())
+		}
+		sb.WriteByte('\n')
+	}
+}
+
+// Unwrap returns the children, errors.Is and errors.As walk the whole tree.
+func (e *MultiError) Unwrap() []error {
+	if e == nil {
+		return nil
+	}
+	return e.errors
+}
+
+// Len returns the number of children.
+func (e *MultiError) Len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.errors)
+}
+
+// Errors returns leaves of the tree in depth first order. Keys of the
+// path to a leaf are joined by dots, so the leaf "zip" in the subtree
+// "address" is labeled as "address.zip".
+func (e *MultiError) Errors() []error {
+	if e == nil {
+		return nil
+	}
+
+	var leaves []error
+	e.walk("", func(key string, err error) {
+		if key != "" {
+			err = WithKey(key, err)
+		}
+		leaves = append(leaves, err)
+	})
+	return leaves
+}
+
+func (e *MultiError) walk(path string, visit func(key string, err error)) {
+	for _, err := range e.errors {
+		key := path
+		if keyErr, ok := err.(*KeyError); ok {
+			key = joinKeys(path, keyErr.Key)
+			err = keyErr.Err
+		}
+
+		if mErr, ok := err.(*MultiError); ok {
+			mErr.walk(key, visit)
+			continue
+		}
+
+		visit(key, err)
+	}
+}
+
+func joinKeys(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// GroupBy groups leaves of the tree, see Errors. A leaf matching one of
+// the sentinels by errors.Is goes to the group named by the sentinel
+// message, the others are grouped by their type like "*fs.PathError".
+func (e *MultiError) GroupBy(sentinels ...error) map[string][]error {
+	groups := make(map[string][]error)
+	for _, leaf := range e.Errors() {
+		name := groupName(leaf, sentinels)
+		groups[name] = append(groups[name], leaf)
+	}
+	return groups
+}
+
+func groupName(leaf error, sentinels []error) string {
+	for _, sentinel := range sentinels {
+		if errors.Is(leaf, sentinel) {
+			return sentinel.Error()
+		}
+	}
+
+	if keyErr, ok := leaf.(*KeyError); ok {
+		leaf = keyErr.Err
+	}
+	return fmt.Sprintf("%T", leaf)
+}
+
+// errorJSON is a node of the rendered tree, a leaf has
+// a message and a subtree has errors
+type errorJSON struct {
+	Key     string      `json:"key,omitempty"`
+	Message string      `json:"message,omitempty"`
+	Errors  []errorJSON `json:"errors,omitempty"`
+}
+
+func newErrorJSON(err error) errorJSON {
+	var node errorJSON
+	if keyErr, ok := err.(*KeyError); ok {
+		node.Key = keyErr.Key
+		err = keyErr.Err
+	}
+
+	mErr, ok := err.(*MultiError)
+	if !ok {
+		node.Message = err.Error()
+		return node
+	}
+
+	node.Errors = make([]errorJSON, 0, len(mErr.errors))
+	for _, child := range mErr.errors {
+		node.Errors = append(node.Errors, newErrorJSON(child))
+	}
+	return node
+}
+
+// MarshalJSON renders the tree as {"errors": [...]}, where a leaf is
+// {"key": "...", "message": "..."} and a subtree is {"key": "...", "errors": [...]}.
+func (e *MultiError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(newErrorJSON(e))
+}
+
+// Append adds errs to err as children, nil errors are skipped. A MultiError
+// err is extended in place, other err becomes the first child of the new
+// MultiError. A MultiError in errs is kept as a subtree.
+func Append(err error, errs ...error) *MultiError {
+	mErr, ok := err.(*MultiError)
+	if mErr == nil {
+		mErr = &MultiError{
+			errors: make([]error, 0, len(errs)+1),
+		}
+		// nil *MultiError is an empty tree
+		if !ok && err != nil {
+			mErr.errors = append(mErr.errors, err)
+		}
+	}
+
+	for _, e := range errs {
+		if e != nil {
+			mErr.errors = append(mErr.errors, e)
+		}
+	}
+
+	if len(mErr.errors) == 0 {
+		return nil
+	}
+
+	return mErr
+}
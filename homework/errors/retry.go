@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"golang_course/internal/clock"
+)
+
+// Clock is a source of time for Retry, tests inject a fake one.
+type Clock = clock.Clock
+
+// PermanentError marks the error which must not be retried.
+type PermanentError struct {
+	Err error
+}
+
+// Permanent marks the error as permanent, it returns nil for nil error.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// RetryPolicy defines how many times and how often Retry calls the function.
+type RetryPolicy struct {
+	// MaxAttempts limits the number of calls, zero means no limit
+	MaxAttempts int
+	// MaxElapsed stops retries if the next call would start later
+	// than MaxElapsed after the first one, zero means no limit
+	MaxElapsed time.Duration
+
+	// InitialDelay is the delay after the first attempt, every next
+	// delay is Multiplier times longer but not longer than MaxDelay.
+	// Zero means the InitialDelay of DefaultRetryPolicy.
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// Multiplier less than 1 means 2
+	Multiplier float64
+	// Jitter in [0, 1] is the part of the delay which is randomized,
+	// the delay is reduced by up to Jitter of it
+	Jitter float64
+
+	// Clock is time.Now and time.After by default
+	Clock Clock
+	// Rand returns a number in [0, 1) for jitter, rand.Float64 by default
+	Rand func() float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 100 * time.Millisecond,
+	MaxDelay:     10 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.5,
+}
+
+// delay returns the delay after the attempt, attempts are counted from 1
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	initial := p.InitialDelay
+	if initial == 0 {
+		initial = DefaultRetryPolicy.InitialDelay
+	}
+
+	delay := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 {
+		delay = min(delay, float64(p.MaxDelay))
+	}
+
+	if p.Jitter > 0 {
+		random := p.Rand
+		if random == nil {
+			random = rand.Float64
+		}
+		delay -= delay * min(p.Jitter, 1) * random()
+	}
+
+	// the delay overflows without MaxDelay after enough attempts
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// Retry calls fn until it succeeds, returns a permanent error, the
+// policy limits are reached or the context is done. A permanent error is
+// found by errors.As, so it may be wrapped or be a part of MultiError.
+// The error is MultiError of errors of all attempts labeled by their
+// numbers followed by the context error if the context is done.
+func Retry(ctx context.Context, policy RetryPolicy, fn func(ctx context.Context) error) error {
+	var timeSource Clock = clock.Real{}
+	if policy.Clock != nil {
+		timeSource = policy.Clock
+	}
+
+	var mErr *MultiError
+	start := timeSource.Now()
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return Append(mErr, err)
+		}
+
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		mErr = Append(mErr, WithKey("attempt "+strconv.Itoa(attempt), err))
+
+		var permanent *PermanentError
+		if errors.As(err, &permanent) {
+			return mErr
+		}
+
+		if policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts {
+			return mErr
+		}
+
+		delay := policy.delay(attempt)
+		if policy.MaxElapsed > 0 && timeSource.Now().Add(delay).Sub(start) > policy.MaxElapsed {
+			return mErr
+		}
+
+		select {
+		case <-timeSource.After(delay):
+		case <-ctx.Done():
+			return Append(mErr, ctx.Err())
+		}
+	}
+}
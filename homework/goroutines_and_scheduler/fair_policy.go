@@ -0,0 +1,176 @@
+package main
+
+import (
+	"container/heap"
+	"maps"
+	"slices"
+	"time"
+)
+
+// strideUnit is divided by group weight to get the group stride
+const strideUnit = 1 << 20
+
+type fairGroup struct {
+	name   string
+	tasks  *TaskQueue
+	stride uint64
+	pass   uint64
+}
+
+// FairPolicy is weighted fair queuing across task groups implemented
+// as stride scheduling: every group advances its pass by the stride
+// inversely proportional to its weight when its task is taken, and
+// the group with the least pass goes next. So under load groups get
+// shares proportional to their weights, tasks within a group are
+// taken by priority.
+type FairPolicy struct {
+	weights map[string]int
+	groups  map[string]*fairGroup
+	// task2Group keeps the group of every queued task
+	task2Group map[int]*fairGroup
+	// pass is the pass of the last taken group, a group becoming active
+	// starts from it, so it can't save up its share while idle
+	pass uint64
+	size int
+}
+
+// NewFairPolicy creates policy with the given group weights,
+// groups without weight have weight 1.
+func NewFairPolicy(weights map[string]int) *FairPolicy {
+	return &FairPolicy{
+		weights:    weights,
+		groups:     make(map[string]*fairGroup),
+		task2Group: make(map[int]*fairGroup),
+	}
+}
+
+func (p *FairPolicy) group(name string) *fairGroup {
+	g, ok := p.groups[name]
+	if !ok {
+		weight := max(p.weights[name], 1)
+		g = &fairGroup{
+			name:   name,
+			tasks:  NewTaskQueue(),
+			stride: strideUnit / uint64(weight),
+		}
+		p.groups[name] = g
+	}
+
+	return g
+}
+
+// next returns the active group with the least pass,
+// ties are broken by the group name to be deterministic
+func (p *FairPolicy) next() *fairGroup {
+	var next *fairGroup
+	for _, g := range p.groups {
+		if g.tasks.Len() == 0 {
+			continue
+		}
+		if next == nil || g.pass < next.pass || g.pass == next.pass && g.name < next.name {
+			next = g
+		}
+	}
+
+	return next
+}
+
+func (p *FairPolicy) Push(task Task, _ time.Time) {
+	g := p.group(task.Group)
+	if g.tasks.Len() == 0 {
+		g.pass = max(g.pass, p.pass)
+	}
+
+	heap.Push(g.tasks, task)
+	p.task2Group[task.Identifier] = g
+	p.size++
+}
+
+func (p *FairPolicy) Pop(_ time.Time) (Task, bool) {
+	g := p.next()
+	if g == nil {
+		return Task{}, false
+	}
+
+	p.pass = g.pass
+	g.pass += g.stride
+
+	task := heap.Pop(g.tasks).(Task)
+	delete(p.task2Group, task.Identifier)
+	p.size--
+
+	return task, true
+}
+
+func (p *FairPolicy) Peek(_ time.Time) (Task, bool) {
+	g := p.next()
+	if g == nil {
+		return Task{}, false
+	}
+
+	return g.tasks.Peek()
+}
+
+func (p *FairPolicy) Remove(taskID int) (Task, bool) {
+	g, ok := p.task2Group[taskID]
+	if !ok {
+		return Task{}, false
+	}
+
+	delete(p.task2Group, taskID)
+	p.size--
+
+	return g.tasks.Remove(taskID)
+}
+
+func (p *FairPolicy) Contains(taskID int) bool {
+	_, ok := p.task2Group[taskID]
+	return ok
+}
+
+func (p *FairPolicy) ChangePriority(taskID int, priority int, _ time.Time) bool {
+	g, ok := p.task2Group[taskID]
+	if !ok {
+		return false
+	}
+
+	g.tasks.UpdatePriority(taskID, priority)
+	return true
+}
+
+func (p *FairPolicy) Len() int {
+	return p.size
+}
+
+func (p *FairPolicy) Tasks(_ time.Time) []Task {
+	// take everything from a copy of the groups
+	clone := &FairPolicy{
+		groups:     make(map[string]*fairGroup, len(p.groups)),
+		task2Group: make(map[int]*fairGroup, len(p.task2Group)),
+		pass:       p.pass,
+		size:       p.size,
+	}
+	for name, g := range p.groups {
+		clone.groups[name] = &fairGroup{
+			name: g.name,
+			tasks: &TaskQueue{
+				items:  slices.Clone(g.tasks.items),
+				id2Idx: maps.Clone(g.tasks.id2Idx),
+			},
+			stride: g.stride,
+			pass:   g.pass,
+		}
+	}
+	for id, g := range p.task2Group {
+		clone.task2Group[id] = clone.groups[g.name]
+	}
+
+	tasks := make([]Task, 0, p.size)
+	for {
+		task, ok := clone.Pop(time.Time{})
+		if !ok {
+			return tasks
+		}
+		tasks = append(tasks, task)
+	}
+}
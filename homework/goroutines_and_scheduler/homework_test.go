@@ -1,9 +1,17 @@
 package main
 
 import (
+	"container/heap"
+	"context"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/quick"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"golang_course/internal/clock"
 )
 
 // go test -v .
@@ -21,18 +29,952 @@ func TestTrace(t *testing.T) {
 	scheduler.AddTask(task4)
 	scheduler.AddTask(task5)
 
-	task := scheduler.GetTask()
+	ctx := context.Background()
+
+	task, err := scheduler.GetTask(ctx)
+	assert.NoError(t, err)
 	assert.Equal(t, task5, task)
 
-	task = scheduler.GetTask()
+	task, err = scheduler.GetTask(ctx)
+	assert.NoError(t, err)
 	assert.Equal(t, task4, task)
 
 	scheduler.ChangeTaskPriority(1, 100)
 
-	task = scheduler.GetTask()
+	task, err = scheduler.GetTask(ctx)
+	assert.NoError(t, err)
 	// Приоритет поменялся, соот-но просто сравнивать с task1 нельзя
 	assert.Equal(t, Task{Identifier: 1, Priority: 100}, task)
 
-	task = scheduler.GetTask()
+	task, err = scheduler.GetTask(ctx)
+	assert.NoError(t, err)
 	assert.Equal(t, task3, task)
 }
+
+func TestGetTaskBlocks(t *testing.T) {
+	t.Run("Empty Scheduler", func(t *testing.T) {
+		scheduler := NewScheduler()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		task, err := scheduler.GetTask(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, Task{}, task)
+	})
+
+	t.Run("Task 0 Is Not Empty Result", func(t *testing.T) {
+		scheduler := NewScheduler()
+		scheduler.AddTask(Task{})
+
+		task, err := scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, Task{}, task)
+	})
+
+	t.Run("Wait For Task", func(t *testing.T) {
+		scheduler := NewScheduler()
+
+		result := make(chan Task)
+		go func() {
+			task, err := scheduler.GetTask(context.Background())
+			assert.NoError(t, err)
+			result <- task
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		scheduler.AddTask(Task{Identifier: 1, Priority: 1})
+
+		select {
+		case task := <-result:
+			assert.Equal(t, Task{Identifier: 1, Priority: 1}, task)
+		case <-time.After(time.Second):
+			assert.Fail(t, "task is not received")
+		}
+	})
+
+	t.Run("Cancel Waiting", func(t *testing.T) {
+		scheduler := NewScheduler()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		result := make(chan error)
+		go func() {
+			_, err := scheduler.GetTask(ctx)
+			result <- err
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+
+		select {
+		case err := <-result:
+			assert.ErrorIs(t, err, context.Canceled)
+		case <-time.After(time.Second):
+			assert.Fail(t, "GetTask is not cancelled")
+		}
+	})
+}
+
+// go test -race -v .
+func TestRun(t *testing.T) {
+	t.Run("All Tasks Handled", func(t *testing.T) {
+		const tasksNumber = 1000
+
+		scheduler := NewScheduler()
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var (
+			handled atomic.Int32
+			mu      sync.Mutex
+			seen    = map[int]int{}
+		)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			scheduler.Run(ctx, 4, func(_ context.Context, task Task) {
+				mu.Lock()
+				seen[task.Identifier]++
+				mu.Unlock()
+
+				if handled.Add(1) == tasksNumber {
+					cancel()
+				}
+			})
+		}()
+
+		var wg sync.WaitGroup
+		for p := 0; p < 4; p++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := p; i < tasksNumber; i += 4 {
+					scheduler.AddTask(Task{Identifier: i, Priority: i % 10})
+					if i%7 == 0 {
+						scheduler.ChangeTaskPriority(i, 100)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "Run is not finished")
+			cancel()
+			<-done
+		}
+
+		assert.Equal(t, int32(tasksNumber), handled.Load())
+		assert.Equal(t, tasksNumber, len(seen))
+		for id, count := range seen {
+			assert.Equal(t, 1, count, "task %d", id)
+		}
+	})
+
+	t.Run("Tasks Dispatched By Priority", func(t *testing.T) {
+		scheduler := NewScheduler()
+		for i := 1; i <= 10; i++ {
+			scheduler.AddTask(Task{Identifier: i, Priority: i})
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var order []int
+		scheduler.Run(ctx, 1, func(_ context.Context, task Task) {
+			order = append(order, task.Priority)
+			if len(order) == 10 {
+				cancel()
+			}
+		})
+
+		assert.Equal(t, []int{10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, order)
+	})
+
+	t.Run("Less Than One Worker", func(t *testing.T) {
+		for _, workers := range []int{0, -1} {
+			scheduler := NewScheduler()
+			assert.NoError(t, scheduler.AddTask(Task{Identifier: 1}))
+
+			ctx, cancel := context.WithCancel(context.Background())
+
+			var handled int
+			scheduler.Run(ctx, workers, func(_ context.Context, task Task) {
+				handled++
+				cancel()
+			})
+			assert.Equal(t, 1, handled)
+		}
+	})
+}
+
+func TestAging(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Without Aging Low Priority Task Starves", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock))
+		scheduler.AddTask(Task{Identifier: 0, Priority: 1})
+
+		for i := 1; i <= 100; i++ {
+			scheduler.AddTask(Task{Identifier: i, Priority: 10})
+			clock.Advance(10 * time.Millisecond)
+
+			task, err := scheduler.GetTask(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, i, task.Identifier)
+		}
+	})
+
+	t.Run("Every Task Is Eventually Served", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock), WithAging(10*time.Millisecond))
+
+		lowPriority := []Task{
+			{Identifier: -1, Priority: 1},
+			{Identifier: -2, Priority: 2},
+			{Identifier: -3, Priority: 3},
+		}
+		for _, task := range lowPriority {
+			scheduler.AddTask(task)
+		}
+
+		served := map[int]int{}
+		for i := 1; i <= 100; i++ {
+			// steady flow of high priority tasks, one per step
+			scheduler.AddTask(Task{Identifier: i, Priority: 10})
+			clock.Advance(10 * time.Millisecond)
+
+			task, err := scheduler.GetTask(ctx)
+			assert.NoError(t, err)
+			served[task.Identifier] = i
+		}
+
+		for _, task := range lowPriority {
+			step, ok := served[task.Identifier]
+			assert.True(t, ok, "task %d is starved", task.Identifier)
+			// it needs about 10 - priority steps to catch up
+			assert.LessOrEqual(t, step, 30)
+		}
+	})
+
+	t.Run("Served Task Keeps User Priority", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock), WithAging(time.Millisecond))
+		scheduler.AddTask(Task{Identifier: 1, Priority: 5})
+		clock.Advance(time.Second)
+
+		task, err := scheduler.GetTask(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, Task{Identifier: 1, Priority: 5}, task)
+	})
+
+	t.Run("Change Priority Keeps Aging Bonus", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock), WithAging(time.Second))
+		scheduler.AddTask(Task{Identifier: 1, Priority: 0})
+		clock.Advance(5 * time.Second)
+		scheduler.AddTask(Task{Identifier: 2, Priority: 7})
+
+		// 3 + 5 seconds of waiting is higher than 7
+		scheduler.ChangeTaskPriority(1, 3)
+
+		task, err := scheduler.GetTask(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, Task{Identifier: 1, Priority: 3}, task)
+	})
+}
+
+func TestTimerWheel(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Fires In Due Order Across Levels", func(t *testing.T) {
+		wheel := newTimerWheel(time.Millisecond, start)
+
+		// delays cover every level and the range out of the wheel
+		delays := []time.Duration{
+			1, 5, 63, 64, 65, 100, 4095, 4096, 5000, 262143, 262144, 300000,
+			1 << 24, 1<<24 + 7, 1 << 25,
+		}
+		for i, d := range delays {
+			assert.True(t, wheel.add(Task{Identifier: i}, start.Add(d*time.Millisecond), 0))
+		}
+		assert.Equal(t, len(delays), wheel.Len())
+
+		var fired []time.Duration
+		now := start
+		for wheel.Len() > 0 {
+			next, ok := wheel.next()
+			assert.True(t, ok)
+			assert.True(t, next.After(now))
+			now = next
+
+			wheel.advance(now, func(task Task, _ bool) {
+				assert.Equal(t, delays[task.Identifier], now.Sub(start)/time.Millisecond)
+				fired = append(fired, delays[task.Identifier])
+			})
+		}
+
+		assert.Equal(t, delays, fired)
+		_, ok := wheel.next()
+		assert.False(t, ok)
+	})
+
+	t.Run("Big Jump Fires Everything", func(t *testing.T) {
+		wheel := newTimerWheel(time.Millisecond, start)
+		for i := 1; i <= 1000; i++ {
+			wheel.add(Task{Identifier: i}, start.Add(time.Duration(i*i)*time.Millisecond), 0)
+		}
+
+		fired := map[int]bool{}
+		wheel.advance(start.Add(time.Hour), func(task Task, _ bool) {
+			fired[task.Identifier] = true
+		})
+		assert.Equal(t, 1000, len(fired))
+		assert.Zero(t, wheel.Len())
+	})
+
+	t.Run("Remove And Replace", func(t *testing.T) {
+		wheel := newTimerWheel(time.Millisecond, start)
+		wheel.add(Task{Identifier: 1, Priority: 1}, start.Add(10*time.Millisecond), 0)
+		wheel.add(Task{Identifier: 2}, start.Add(10*time.Millisecond), 0)
+		wheel.add(Task{Identifier: 1, Priority: 2}, start.Add(20*time.Millisecond), 0)
+		assert.True(t, wheel.remove(2))
+		assert.False(t, wheel.remove(2))
+
+		var fired []Task
+		wheel.advance(start.Add(time.Second), func(task Task, _ bool) {
+			fired = append(fired, task)
+		})
+		assert.Equal(t, []Task{{Identifier: 1, Priority: 2}}, fired)
+	})
+
+	t.Run("Past Moment Is Not Added", func(t *testing.T) {
+		wheel := newTimerWheel(time.Millisecond, start)
+		wheel.advance(start.Add(time.Second), func(Task, bool) {})
+
+		assert.False(t, wheel.add(Task{Identifier: 1}, start, 0))
+		assert.Zero(t, wheel.Len())
+	})
+}
+
+func TestDelayedTasks(t *testing.T) {
+	t.Run("Schedule At", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock))
+		scheduler.ScheduleAt(Task{Identifier: 1, Priority: 1}, clock.Now().Add(time.Second))
+		scheduler.ScheduleAt(Task{Identifier: 2, Priority: 2}, clock.Now().Add(2*time.Second))
+		scheduler.ScheduleAt(Task{Identifier: 3, Priority: 3}, clock.Now().Add(-time.Second))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		task, err := scheduler.GetTask(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, task.Identifier)
+
+		_, err = scheduler.GetTask(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		clock.Advance(3 * time.Second)
+
+		// both are due, priority decides
+		task, err = scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, task.Identifier)
+
+		task, err = scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 1, task.Identifier)
+	})
+
+	t.Run("Blocked GetTask Wakes Up When Task Is Due", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock))
+		scheduler.ScheduleAt(Task{Identifier: 1}, clock.Now().Add(time.Minute))
+
+		result := make(chan Task)
+		go func() {
+			task, err := scheduler.GetTask(context.Background())
+			assert.NoError(t, err)
+			result <- task
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		for i := 0; i < 60; i++ {
+			clock.Advance(time.Second)
+		}
+
+		select {
+		case task := <-result:
+			assert.Equal(t, 1, task.Identifier)
+		case <-time.After(time.Second):
+			assert.Fail(t, "task is not received")
+		}
+	})
+
+	t.Run("Due Task Is Queued", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock))
+		assert.NoError(t, scheduler.ScheduleAt(Task{Identifier: 1, Priority: 1}, clock.Now().Add(time.Second)))
+		assert.NoError(t, scheduler.ScheduleAt(Task{Identifier: 2, Priority: 2}, clock.Now().Add(2*time.Second)))
+		assert.False(t, scheduler.ContainsTask(1))
+
+		clock.Advance(time.Second)
+		assert.True(t, scheduler.ContainsTask(1))
+		assert.True(t, scheduler.RemoveTask(1))
+
+		clock.Advance(time.Second)
+		scheduler.ChangeTaskPriority(2, 5)
+
+		// the removed task is not served
+		assert.Equal(t, []Task{{Identifier: 2, Priority: 5}}, scheduler.Dump())
+	})
+
+	t.Run("Schedule Every And Cancel", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock))
+		assert.ErrorIs(t, scheduler.ScheduleEvery(Task{Identifier: 1}, 0), ErrInvalidInterval)
+		assert.NoError(t, scheduler.ScheduleEvery(Task{Identifier: 1, Priority: 1}, 5*time.Second))
+
+		ctx := context.Background()
+		for i := 0; i < 3; i++ {
+			clock.Advance(5 * time.Second)
+			task, err := scheduler.GetTask(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, task.Identifier)
+		}
+
+		// occurrences are not duplicated while the task is still queued
+		clock.Advance(20 * time.Second)
+		task, err := scheduler.GetTask(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, task.Identifier)
+
+		assert.True(t, scheduler.Cancel(1))
+		assert.False(t, scheduler.Cancel(1))
+
+		clock.Advance(time.Minute)
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err = scheduler.GetTask(timeout)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestTaskLifecycle(t *testing.T) {
+	t.Run("Peek Contains Remove", func(t *testing.T) {
+		scheduler := NewScheduler()
+
+		_, ok := scheduler.PeekTask()
+		assert.False(t, ok)
+
+		for i := 1; i <= 5; i++ {
+			assert.NoError(t, scheduler.AddTask(Task{Identifier: i, Priority: i * 10}))
+		}
+
+		task, ok := scheduler.PeekTask()
+		assert.True(t, ok)
+		assert.Equal(t, Task{Identifier: 5, Priority: 50}, task)
+		assert.True(t, scheduler.ContainsTask(5))
+
+		assert.True(t, scheduler.RemoveTask(5))
+		assert.False(t, scheduler.RemoveTask(5))
+		assert.False(t, scheduler.ContainsTask(5))
+		assert.True(t, scheduler.RemoveTask(2))
+
+		var order []int
+		for i := 0; i < 3; i++ {
+			task, err := scheduler.GetTask(context.Background())
+			assert.NoError(t, err)
+			order = append(order, task.Identifier)
+		}
+		assert.Equal(t, []int{4, 3, 1}, order)
+	})
+
+	t.Run("Reject Duplicate", func(t *testing.T) {
+		scheduler := NewScheduler()
+		assert.NoError(t, scheduler.AddTask(Task{Identifier: 1, Priority: 1}))
+		assert.ErrorIs(t, scheduler.AddTask(Task{Identifier: 1, Priority: 2}), ErrDuplicateTask)
+		assert.ErrorIs(t, scheduler.ScheduleAt(Task{Identifier: 1, Priority: 3}, time.Time{}), ErrDuplicateTask)
+
+		task, err := scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, Task{Identifier: 1, Priority: 1}, task)
+		assert.False(t, scheduler.ContainsTask(1))
+
+		// identifier can be reused after the task is taken
+		assert.NoError(t, scheduler.AddTask(Task{Identifier: 1, Priority: 2}))
+	})
+
+	t.Run("Replace Duplicate", func(t *testing.T) {
+		scheduler := NewScheduler(WithDuplicatePolicy(DuplicateReplace))
+		assert.NoError(t, scheduler.AddTask(Task{Identifier: 1, Priority: 1}))
+		assert.NoError(t, scheduler.AddTask(Task{Identifier: 2, Priority: 5}))
+		assert.NoError(t, scheduler.AddTask(Task{Identifier: 1, Priority: 10}))
+
+		task, err := scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, Task{Identifier: 1, Priority: 10}, task)
+
+		task, err = scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, Task{Identifier: 2, Priority: 5}, task)
+		assert.False(t, scheduler.ContainsTask(1))
+	})
+
+	t.Run("Delayed Duplicate", func(t *testing.T) {
+		for _, test := range []struct {
+			policy   DuplicatePolicy
+			priority int
+			rejected int
+		}{
+			{policy: DuplicateReject, priority: 1, rejected: 1},
+			{policy: DuplicateReplace, priority: 10},
+		} {
+			clock := clock.NewFake()
+			scheduler := NewScheduler(WithClock(clock), WithDuplicatePolicy(test.policy))
+
+			assert.NoError(t, scheduler.AddTask(Task{Identifier: 1, Priority: 1}))
+			assert.NoError(t, scheduler.ScheduleAt(Task{Identifier: 1, Priority: 10}, clock.Now().Add(time.Second)))
+			clock.Advance(2 * time.Second)
+
+			task, ok := scheduler.PeekTask()
+			assert.True(t, ok)
+			assert.Equal(t, Task{Identifier: 1, Priority: test.priority}, task)
+			assert.Equal(t, test.rejected, scheduler.Stats().Rejected)
+			assert.Equal(t, 1, scheduler.Stats().Depth)
+		}
+	})
+}
+
+// checkTaskQueue verifies that id2Idx matches positions of the tasks
+// and the heap ordering holds
+func checkTaskQueue(q *TaskQueue) bool {
+	if len(q.id2Idx) != len(q.items) {
+		return false
+	}
+
+	for i, task := range q.items {
+		if idx, ok := q.id2Idx[task.Identifier]; !ok || idx != i {
+			return false
+		}
+		if i > 0 && q.Less(i, (i-1)/2) {
+			return false
+		}
+	}
+
+	return true
+}
+
+type queueOperation struct {
+	Kind     uint8
+	ID       uint8
+	Priority int8
+}
+
+func TestTaskQueueIndexProperty(t *testing.T) {
+	property := func(operations []queueOperation) bool {
+		q := NewTaskQueue()
+		expected := map[int]int{}
+
+		for _, op := range operations {
+			id, priority := int(op.ID%32), int(op.Priority)
+
+			switch op.Kind % 4 {
+			case 0:
+				if !q.Contains(id) {
+					heap.Push(q, Task{Identifier: id, Priority: priority})
+					expected[id] = priority
+				}
+			case 1:
+				if q.Len() > 0 {
+					task := heap.Pop(q).(Task)
+					for _, p := range expected {
+						if p > task.Priority {
+							return false
+						}
+					}
+					delete(expected, task.Identifier)
+				}
+			case 2:
+				q.UpdatePriority(id, priority)
+				if _, ok := expected[id]; ok {
+					expected[id] = priority
+				}
+			case 3:
+				_, removed := q.Remove(id)
+				_, ok := expected[id]
+				if removed != ok {
+					return false
+				}
+				delete(expected, id)
+			}
+
+			if !checkTaskQueue(q) || q.Len() != len(expected) {
+				return false
+			}
+
+			if top, ok := q.Peek(); ok && expected[top.Identifier] != top.Priority {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	assert.NoError(t, quick.Check(property, &quick.Config{MaxCount: 1000}))
+}
+
+func TestFairPolicy(t *testing.T) {
+	t.Run("Shares By Weight", func(t *testing.T) {
+		policy := NewFairPolicy(map[string]int{"a": 3, "b": 1})
+		for i := 0; i < 100; i++ {
+			policy.Push(Task{Identifier: i, Group: "a"}, time.Time{})
+			policy.Push(Task{Identifier: 100 + i, Group: "b"}, time.Time{})
+		}
+		assert.Equal(t, 200, policy.Len())
+
+		taken := map[string]int{}
+		for i := 0; i < 40; i++ {
+			task, ok := policy.Pop(time.Time{})
+			assert.True(t, ok)
+			taken[task.Group]++
+		}
+		assert.Equal(t, map[string]int{"a": 30, "b": 10}, taken)
+	})
+
+	t.Run("Priority Within Group", func(t *testing.T) {
+		policy := NewFairPolicy(nil)
+		policy.Push(Task{Identifier: 1, Priority: 1, Group: "a"}, time.Time{})
+		policy.Push(Task{Identifier: 2, Priority: 5, Group: "a"}, time.Time{})
+		policy.Push(Task{Identifier: 3, Priority: 3, Group: "a"}, time.Time{})
+		assert.True(t, policy.ChangePriority(1, 10, time.Time{}))
+
+		var order []int
+		for policy.Len() > 0 {
+			task, _ := policy.Pop(time.Time{})
+			order = append(order, task.Identifier)
+		}
+		assert.Equal(t, []int{1, 2, 3}, order)
+	})
+
+	t.Run("Idle Group Does Not Save Up Share", func(t *testing.T) {
+		policy := NewFairPolicy(nil)
+		for i := 0; i < 100; i++ {
+			policy.Push(Task{Identifier: i, Group: "busy"}, time.Time{})
+		}
+		for i := 0; i < 50; i++ {
+			policy.Pop(time.Time{})
+		}
+
+		for i := 0; i < 10; i++ {
+			policy.Push(Task{Identifier: 100 + i, Group: "idle"}, time.Time{})
+		}
+
+		taken := map[string]int{}
+		for i := 0; i < 10; i++ {
+			task, _ := policy.Pop(time.Time{})
+			taken[task.Group]++
+		}
+		assert.InDelta(t, 5, taken["idle"], 1)
+	})
+
+	t.Run("Remove Contains", func(t *testing.T) {
+		policy := NewFairPolicy(nil)
+		policy.Push(Task{Identifier: 1, Group: "a"}, time.Time{})
+		assert.True(t, policy.Contains(1))
+
+		task, ok := policy.Remove(1)
+		assert.True(t, ok)
+		assert.Equal(t, Task{Identifier: 1, Group: "a"}, task)
+		assert.False(t, policy.Contains(1))
+		assert.Zero(t, policy.Len())
+
+		_, ok = policy.Peek(time.Time{})
+		assert.False(t, ok)
+	})
+}
+
+func TestMLFQPolicy(t *testing.T) {
+	timeSlices := []time.Duration{time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond}
+
+	t.Run("Demotion", func(t *testing.T) {
+		policy := NewMLFQPolicy(timeSlices, 0)
+		now := time.Time{}
+
+		policy.Push(Task{Identifier: 1}, now)
+		task, _ := policy.Pop(now)
+		assert.Equal(t, time.Millisecond, policy.TimeSlice(1))
+
+		// used the whole slice, moves down
+		policy.Requeue(task, time.Millisecond, now)
+		policy.Push(Task{Identifier: 2}, now)
+
+		task, _ = policy.Pop(now)
+		assert.Equal(t, 2, task.Identifier)
+		policy.Finish(2)
+
+		task, _ = policy.Pop(now)
+		assert.Equal(t, 1, task.Identifier)
+		assert.Equal(t, 10*time.Millisecond, policy.TimeSlice(1))
+
+		// yielded before the slice end, stays on the level
+		policy.Requeue(task, time.Millisecond, now)
+		task, _ = policy.Pop(now)
+		assert.Equal(t, 10*time.Millisecond, policy.TimeSlice(1))
+
+		policy.Requeue(task, 10*time.Millisecond, now)
+		task, _ = policy.Pop(now)
+		policy.Requeue(task, time.Second, now)
+		assert.Equal(t, 100*time.Millisecond, policy.TimeSlice(1))
+
+		policy.Remove(1)
+		assert.Zero(t, policy.TimeSlice(1))
+	})
+
+	t.Run("Boost", func(t *testing.T) {
+		policy := NewMLFQPolicy(timeSlices, time.Second)
+		now := time.Time{}.Add(time.Hour)
+
+		policy.Push(Task{Identifier: 1}, now)
+		task, _ := policy.Pop(now)
+		policy.Requeue(task, time.Second, now)
+		assert.Equal(t, 10*time.Millisecond, policy.TimeSlice(1))
+
+		policy.Push(Task{Identifier: 2}, now)
+
+		now = now.Add(time.Second)
+		task, _ = policy.Peek(now)
+		assert.Equal(t, 2, task.Identifier)
+		assert.Equal(t, time.Millisecond, policy.TimeSlice(1))
+	})
+}
+
+func TestSimulation(t *testing.T) {
+	t.Run("Fair Share Between Tenants", func(t *testing.T) {
+		var workload []SimulatedTask
+		for i := 0; i < 300; i++ {
+			workload = append(workload,
+				SimulatedTask{Task: Task{Identifier: i, Group: "gold"}, Work: time.Millisecond},
+				SimulatedTask{Task: Task{Identifier: 1000 + i, Group: "bronze"}, Work: time.Millisecond},
+			)
+		}
+
+		report := Simulate(NewFairPolicy(map[string]int{"gold": 2, "bronze": 1}), workload, 2)
+		assert.Equal(t, 300*time.Millisecond, report.Duration)
+
+		gold, bronze := report.Groups["gold"], report.Groups["bronze"]
+		assert.Equal(t, 300, gold.Completed)
+		assert.Equal(t, 300, bronze.Completed)
+		assert.Equal(t, float64(1000), gold.Throughput)
+
+		// gold gets 2/3 of workers while it has tasks
+		assert.InDelta(t, 225*time.Millisecond, gold.WaitMax, float64(2*time.Millisecond))
+		assert.Less(t, gold.WaitP50, bronze.WaitP50)
+	})
+
+	t.Run("Short Tasks Are Not Stuck Behind Long One", func(t *testing.T) {
+		workload := []SimulatedTask{
+			{Task: Task{Identifier: 0, Group: "long"}, Work: time.Second},
+		}
+		for i := 1; i <= 50; i++ {
+			workload = append(workload, SimulatedTask{
+				Task:    Task{Identifier: i, Group: "short"},
+				Arrival: time.Duration(i) * 10 * time.Millisecond,
+				Work:    time.Millisecond,
+			})
+		}
+
+		priority := Simulate(NewPriorityPolicy(0), workload, 1)
+		assert.Equal(t, 50, priority.Groups["short"].Completed)
+		assert.Greater(t, priority.Groups["short"].WaitP50, 400*time.Millisecond)
+
+		mlfq := Simulate(NewMLFQPolicy([]time.Duration{
+			time.Millisecond, 10 * time.Millisecond, 100 * time.Millisecond,
+		}, 0), workload, 1)
+		assert.Equal(t, 50, mlfq.Groups["short"].Completed)
+		assert.Equal(t, 1, mlfq.Groups["long"].Completed)
+		assert.LessOrEqual(t, mlfq.Groups["short"].WaitP99, 100*time.Millisecond)
+		assert.Equal(t, priority.Duration, mlfq.Duration)
+	})
+}
+
+func TestSchedulerWithPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Fair Policy", func(t *testing.T) {
+		scheduler := NewScheduler(WithPolicy(NewFairPolicy(map[string]int{"a": 2})))
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, scheduler.AddTask(Task{Identifier: i, Group: "a"}))
+			assert.NoError(t, scheduler.AddTask(Task{Identifier: 10 + i, Group: "b"}))
+		}
+
+		taken := map[string]int{}
+		for i := 0; i < 6; i++ {
+			task, err := scheduler.GetTask(ctx)
+			assert.NoError(t, err)
+			taken[task.Group]++
+		}
+		assert.Equal(t, map[string]int{"a": 4, "b": 2}, taken)
+	})
+
+	t.Run("MLFQ Policy Requeue", func(t *testing.T) {
+		scheduler := NewScheduler(WithPolicy(NewMLFQPolicy([]time.Duration{time.Millisecond, time.Second}, 0)))
+		assert.NoError(t, scheduler.AddTask(Task{Identifier: 1}))
+
+		task, err := scheduler.GetTask(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Millisecond, scheduler.TimeSlice(1))
+
+		assert.NoError(t, scheduler.Requeue(task, time.Millisecond))
+		assert.ErrorIs(t, scheduler.Requeue(task, time.Millisecond), ErrDuplicateTask)
+
+		task, err = scheduler.GetTask(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, time.Second, scheduler.TimeSlice(1))
+
+		scheduler.Finish(task.Identifier)
+		assert.Zero(t, scheduler.TimeSlice(1))
+	})
+
+	t.Run("MLFQ Policy Run Requeue", func(t *testing.T) {
+		slices := []time.Duration{time.Millisecond, time.Second, time.Minute}
+		scheduler := NewScheduler(WithPolicy(NewMLFQPolicy(slices, 0)))
+		assert.NoError(t, scheduler.AddTask(Task{Identifier: 1}))
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var (
+			calls      atomic.Int32
+			lastSlice  time.Duration
+			takenAgain = make(chan struct{})
+		)
+		scheduler.Run(ctx, 2, func(ctx context.Context, task Task) {
+			switch calls.Add(1) {
+			case 1:
+				assert.NoError(t, scheduler.Requeue(task, time.Millisecond))
+				// the other worker takes the task before this one is done
+				<-takenAgain
+			case 2:
+				close(takenAgain)
+				// let the first worker finish its take
+				time.Sleep(20 * time.Millisecond)
+				assert.NoError(t, scheduler.Requeue(task, time.Second))
+			default:
+				lastSlice = scheduler.TimeSlice(task.Identifier)
+				cancel()
+			}
+		})
+
+		assert.Equal(t, int32(3), calls.Load())
+		assert.Equal(t, time.Minute, lastSlice)
+		assert.Zero(t, scheduler.TimeSlice(1))
+	})
+
+	t.Run("Default Policy Requeue", func(t *testing.T) {
+		scheduler := NewScheduler()
+		assert.NoError(t, scheduler.Requeue(Task{Identifier: 1}, time.Second))
+		assert.True(t, scheduler.ContainsTask(1))
+		assert.Zero(t, scheduler.TimeSlice(1))
+	})
+}
+
+func TestStatsAndDump(t *testing.T) {
+	t.Run("Stats", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock))
+
+		stats := scheduler.Stats()
+		assert.Zero(t, stats.Depth)
+		assert.Zero(t, stats.WaitTime.Count)
+		assert.Equal(t, len(waitTimeBounds)+1, len(stats.WaitTime.Counts))
+
+		for i := 1; i <= 5; i++ {
+			assert.NoError(t, scheduler.AddTask(Task{Identifier: i, Priority: i % 2}))
+		}
+		scheduler.ChangeTaskPriority(2, 5)
+		scheduler.ChangeTaskPriority(42, 5)
+		scheduler.RemoveTask(3)
+		scheduler.ScheduleAt(Task{Identifier: 10}, clock.Now().Add(time.Hour))
+
+		clock.Advance(3 * time.Millisecond)
+		_, err := scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+
+		clock.Advance(time.Minute)
+		_, err = scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+
+		stats = scheduler.Stats()
+		assert.Equal(t, 2, stats.Depth)
+		assert.Equal(t, map[int]int{0: 1, 1: 1}, stats.ByPriority)
+		assert.Equal(t, 1, stats.Scheduled)
+		assert.Equal(t, 5, stats.Enqueued)
+		assert.Equal(t, 2, stats.Dequeued)
+		assert.Equal(t, 1, stats.Removed)
+		assert.Equal(t, 1, stats.Reprioritized)
+
+		assert.Equal(t, 2, stats.WaitTime.Count)
+		assert.Equal(t, 1, stats.WaitTime.Counts[1])
+		assert.Equal(t, 1, stats.WaitTime.Counts[len(waitTimeBounds)])
+		assert.Equal(t, (2*3*time.Millisecond+time.Minute)/2, stats.WaitTime.Mean())
+
+		// snapshot is not affected by later changes
+		_, err = scheduler.GetTask(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, 2, stats.WaitTime.Count)
+	})
+
+	t.Run("Dump Priority Policy", func(t *testing.T) {
+		clock := clock.NewFake()
+		scheduler := NewScheduler(WithClock(clock), WithAging(time.Second))
+		for i := 1; i <= 5; i++ {
+			assert.NoError(t, scheduler.AddTask(Task{Identifier: i, Priority: i}))
+		}
+		clock.Advance(10 * time.Second)
+		assert.NoError(t, scheduler.AddTask(Task{Identifier: 6, Priority: 6}))
+
+		expected := []Task{
+			{Identifier: 5, Priority: 5},
+			{Identifier: 4, Priority: 4},
+			{Identifier: 3, Priority: 3},
+			{Identifier: 2, Priority: 2},
+			{Identifier: 1, Priority: 1},
+			{Identifier: 6, Priority: 6},
+		}
+		assert.Equal(t, expected, scheduler.Dump())
+		assert.Equal(t, expected, scheduler.Dump())
+
+		for _, task := range expected {
+			taken, err := scheduler.GetTask(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, task, taken)
+		}
+		assert.Empty(t, scheduler.Dump())
+	})
+
+	t.Run("Dump Fair And MLFQ Policies", func(t *testing.T) {
+		policies := []Policy{
+			NewFairPolicy(map[string]int{"a": 2}),
+			NewMLFQPolicy([]time.Duration{time.Millisecond}, 0),
+		}
+
+		for _, policy := range policies {
+			scheduler := NewScheduler(WithPolicy(policy))
+			for i := 0; i < 6; i++ {
+				assert.NoError(t, scheduler.AddTask(Task{Identifier: i, Priority: i, Group: []string{"a", "b"}[i%2]}))
+			}
+
+			dump := scheduler.Dump()
+			assert.Equal(t, 6, len(dump))
+			for _, task := range dump {
+				taken, err := scheduler.GetTask(context.Background())
+				assert.NoError(t, err)
+				assert.Equal(t, task, taken)
+			}
+		}
+	})
+}
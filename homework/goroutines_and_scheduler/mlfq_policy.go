@@ -0,0 +1,179 @@
+package main
+
+import (
+	"container/list"
+	"time"
+)
+
+// MLFQPolicy is a multi-level feedback queue. New tasks enter the top
+// level, a task which uses the whole time slice of its level moves one
+// level down, where slices are usually longer. Levels are served from
+// the top, tasks within a level are taken in FIFO order, priorities
+// are not used. To prevent starvation of long tasks all tasks are
+// periodically boosted back to the top level.
+type MLFQPolicy struct {
+	slices        []time.Duration
+	boostInterval time.Duration
+	boostedAt     time.Time
+
+	levels []*list.List
+	queued map[int]*list.Element
+	// taskLevel keeps the level of every queued or taken task
+	// until it is finished
+	taskLevel map[int]int
+}
+
+// NewMLFQPolicy creates policy with a level per time slice, the first
+// one is the top level. Non-positive boost interval disables boosting.
+func NewMLFQPolicy(slices []time.Duration, boostInterval time.Duration) *MLFQPolicy {
+	levels := make([]*list.List, len(slices))
+	for i := range levels {
+		levels[i] = list.New()
+	}
+
+	return &MLFQPolicy{
+		slices:        slices,
+		boostInterval: boostInterval,
+		levels:        levels,
+		queued:        make(map[int]*list.Element),
+		taskLevel:     make(map[int]int),
+	}
+}
+
+// boost moves all tasks to the top level once per boost interval
+func (p *MLFQPolicy) boost(now time.Time) {
+	if p.boostInterval <= 0 || now.Sub(p.boostedAt) < p.boostInterval {
+		return
+	}
+	p.boostedAt = now
+
+	for _, level := range p.levels[1:] {
+		for e := level.Front(); e != nil; e = e.Next() {
+			task := e.Value.(Task)
+			p.queued[task.Identifier] = p.levels[0].PushBack(task)
+		}
+		level.Init()
+	}
+
+	for id := range p.taskLevel {
+		p.taskLevel[id] = 0
+	}
+}
+
+func (p *MLFQPolicy) push(task Task, level int) {
+	p.queued[task.Identifier] = p.levels[level].PushBack(task)
+	p.taskLevel[task.Identifier] = level
+}
+
+func (p *MLFQPolicy) Push(task Task, now time.Time) {
+	p.boost(now)
+	p.push(task, 0)
+}
+
+func (p *MLFQPolicy) Requeue(task Task, used time.Duration, now time.Time) {
+	p.boost(now)
+
+	level := p.taskLevel[task.Identifier]
+	if used >= p.slices[level] && level < len(p.levels)-1 {
+		level++
+	}
+
+	p.push(task, level)
+}
+
+func (p *MLFQPolicy) front() *list.Element {
+	for _, level := range p.levels {
+		if level.Len() > 0 {
+			return level.Front()
+		}
+	}
+	return nil
+}
+
+func (p *MLFQPolicy) Pop(now time.Time) (Task, bool) {
+	p.boost(now)
+
+	e := p.front()
+	if e == nil {
+		return Task{}, false
+	}
+
+	task := e.Value.(Task)
+	p.levels[p.taskLevel[task.Identifier]].Remove(e)
+	delete(p.queued, task.Identifier)
+
+	return task, true
+}
+
+func (p *MLFQPolicy) Peek(now time.Time) (Task, bool) {
+	p.boost(now)
+
+	e := p.front()
+	if e == nil {
+		return Task{}, false
+	}
+
+	return e.Value.(Task), true
+}
+
+func (p *MLFQPolicy) Remove(taskID int) (Task, bool) {
+	e, ok := p.queued[taskID]
+	if !ok {
+		return Task{}, false
+	}
+
+	task := p.levels[p.taskLevel[taskID]].Remove(e).(Task)
+	delete(p.queued, taskID)
+	delete(p.taskLevel, taskID)
+
+	return task, true
+}
+
+func (p *MLFQPolicy) Contains(taskID int) bool {
+	_, ok := p.queued[taskID]
+	return ok
+}
+
+// ChangePriority updates priority of the queued task,
+// it doesn't affect the order of the tasks.
+func (p *MLFQPolicy) ChangePriority(taskID int, priority int, _ time.Time) bool {
+	e, ok := p.queued[taskID]
+	if !ok {
+		return false
+	}
+
+	task := e.Value.(Task)
+	task.Priority = priority
+	e.Value = task
+
+	return true
+}
+
+func (p *MLFQPolicy) Len() int {
+	return len(p.queued)
+}
+
+func (p *MLFQPolicy) TimeSlice(taskID int) time.Duration {
+	level, ok := p.taskLevel[taskID]
+	if !ok {
+		return 0
+	}
+	return p.slices[level]
+}
+
+func (p *MLFQPolicy) Finish(taskID int) {
+	delete(p.taskLevel, taskID)
+}
+
+func (p *MLFQPolicy) Tasks(now time.Time) []Task {
+	p.boost(now)
+
+	tasks := make([]Task, 0, len(p.queued))
+	for _, level := range p.levels {
+		for e := level.Front(); e != nil; e = e.Next() {
+			tasks = append(tasks, e.Value.(Task))
+		}
+	}
+
+	return tasks
+}
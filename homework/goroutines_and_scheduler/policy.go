@@ -0,0 +1,171 @@
+package main
+
+import (
+	"container/heap"
+	"maps"
+	"slices"
+	"time"
+)
+
+// Policy decides in which order the scheduler hands out tasks.
+// Policies are not safe for concurrent use, the scheduler guards them.
+// Tasks are returned with the priority set by the user.
+type Policy interface {
+	Push(task Task, now time.Time)
+	Pop(now time.Time) (Task, bool)
+	Peek(now time.Time) (Task, bool)
+	Remove(taskID int) (Task, bool)
+	Contains(taskID int) bool
+	ChangePriority(taskID int, priority int, now time.Time) bool
+	Len() int
+	// Tasks returns the queued tasks in the order they would be taken
+	Tasks(now time.Time) []Task
+}
+
+// FeedbackPolicy is a policy which adapts to how long tasks actually run.
+type FeedbackPolicy interface {
+	Policy
+	// TimeSlice returns how long the task taken by Pop may run
+	// before it has to be requeued
+	TimeSlice(taskID int) time.Duration
+	// Requeue puts back the unfinished task which ran for used time
+	Requeue(task Task, used time.Duration, now time.Time)
+	// Finish forgets the finished task
+	Finish(taskID int)
+}
+
+// waitingTask keeps priority set by the user and the time
+// when the task was added to the queue
+type waitingTask struct {
+	priority int
+	addedAt  time.Time
+}
+
+// PriorityPolicy hands out tasks with higher priority first.
+// With positive aging interval a waiting task gains +1 priority
+// for every interval it spends in the queue, so low priority tasks
+// are not starved by a steady flow of high priority ones.
+type PriorityPolicy struct {
+	tasks         *TaskQueue
+	waiting       map[int]waitingTask
+	agingInterval time.Duration
+	agedAt        time.Time
+}
+
+func NewPriorityPolicy(agingInterval time.Duration) *PriorityPolicy {
+	return &PriorityPolicy{
+		tasks:         NewTaskQueue(),
+		waiting:       make(map[int]waitingTask),
+		agingInterval: agingInterval,
+	}
+}
+
+// age raises priorities of the waiting tasks according to their waiting
+// time. It is applied lazily before taking a task and at most once
+// per aging interval, queue positions are restored by heap.Fix.
+func (p *PriorityPolicy) age(now time.Time) {
+	if p.agingInterval <= 0 {
+		return
+	}
+
+	if now.Sub(p.agedAt) < p.agingInterval {
+		return
+	}
+	p.agedAt = now
+
+	for id, task := range p.waiting {
+		if priority := p.agedPriority(task, now); priority != task.priority {
+			p.tasks.UpdatePriority(id, priority)
+		}
+	}
+}
+
+// agedPriority returns priority of the task with the bonus for waiting
+func (p *PriorityPolicy) agedPriority(task waitingTask, now time.Time) int {
+	if p.agingInterval <= 0 {
+		return task.priority
+	}
+	return task.priority + int(now.Sub(task.addedAt)/p.agingInterval)
+}
+
+func (p *PriorityPolicy) Push(task Task, now time.Time) {
+	heap.Push(p.tasks, task)
+	p.waiting[task.Identifier] = waitingTask{
+		priority: task.Priority,
+		addedAt:  now,
+	}
+}
+
+func (p *PriorityPolicy) Pop(now time.Time) (Task, bool) {
+	task, ok := p.Peek(now)
+	if !ok {
+		return Task{}, false
+	}
+
+	return p.Remove(task.Identifier)
+}
+
+func (p *PriorityPolicy) Peek(now time.Time) (Task, bool) {
+	p.age(now)
+
+	task, ok := p.tasks.Peek()
+	if ok {
+		task.Priority = p.waiting[task.Identifier].priority
+	}
+
+	return task, ok
+}
+
+func (p *PriorityPolicy) Remove(taskID int) (Task, bool) {
+	task, ok := p.tasks.Remove(taskID)
+	if !ok {
+		return Task{}, false
+	}
+
+	task.Priority = p.waiting[taskID].priority
+	delete(p.waiting, taskID)
+
+	return task, true
+}
+
+func (p *PriorityPolicy) Contains(taskID int) bool {
+	return p.tasks.Contains(taskID)
+}
+
+func (p *PriorityPolicy) ChangePriority(taskID int, priority int, now time.Time) bool {
+	task, ok := p.waiting[taskID]
+	if !ok {
+		return false
+	}
+
+	task.priority = priority
+	p.waiting[taskID] = task
+
+	// keep the bonus already earned by waiting
+	p.tasks.UpdatePriority(taskID, p.agedPriority(task, now))
+
+	return true
+}
+
+func (p *PriorityPolicy) Len() int {
+	return p.tasks.Len()
+}
+
+func (p *PriorityPolicy) Tasks(now time.Time) []Task {
+	p.age(now)
+
+	// pop everything from a copy of the heap
+	clone := &TaskQueue{
+		items:  slices.Clone(p.tasks.items),
+		id2Idx: maps.Clone(p.tasks.id2Idx),
+	}
+
+	tasks := make([]Task, 0, clone.Len())
+	for clone.Len() > 0 {
+		task := heap.Pop(clone).(Task)
+		task.Priority = p.waiting[task.Identifier].priority
+		tasks = append(tasks, task)
+	}
+
+	return tasks
+}
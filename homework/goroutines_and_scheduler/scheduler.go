@@ -1,30 +1,433 @@
 package main
 
 import (
-	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
 )
 
+const defaultTick = time.Millisecond
+
+var (
+	ErrInvalidInterval = errors.New("interval must be positive")
+	ErrDuplicateTask   = errors.New("task with the same identifier is already in the queue")
+)
+
+// DuplicatePolicy defines what happens when a task is added while
+// the task with the same identifier is still in the queue.
+type DuplicatePolicy int
+
+const (
+	// DuplicateReject keeps the queued task and returns ErrDuplicateTask
+	DuplicateReject DuplicatePolicy = iota
+	// DuplicateReplace removes the queued task and adds the new one
+	DuplicateReplace
+)
+
+type Option func(*Scheduler)
+
+// WithAging enables priority aging of the default priority policy,
+// see NewPriorityPolicy. It is ignored if the policy is set by WithPolicy.
+func WithAging(interval time.Duration) Option {
+	return func(s *Scheduler) {
+		s.agingInterval = interval
+	}
+}
+
+// WithPolicy sets the order tasks are handed out in,
+// strict priority order is used by default.
+func WithPolicy(policy Policy) Option {
+	return func(s *Scheduler) {
+		s.policy = policy
+	}
+}
+
+func WithClock(clock Clock) Option {
+	return func(s *Scheduler) {
+		s.clock = clock
+	}
+}
+
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(s *Scheduler) {
+		s.duplicatePolicy = policy
+	}
+}
+
+// WithTick sets resolution of the timer wheel for delayed and recurring tasks.
+func WithTick(tick time.Duration) Option {
+	return func(s *Scheduler) {
+		s.tick = tick
+	}
+}
+
+// Scheduler is a queue of tasks safe for concurrent use. Tasks are handed
+// out in the order defined by the policy, by default tasks with higher
+// priority are taken first.
 type Scheduler struct {
-	tasks *TaskQueue
+	mu     sync.Mutex
+	policy Policy
+	// added is closed and replaced on every added task
+	// to wake up all waiting consumers
+	added chan struct{}
+
+	clock         Clock
+	agingInterval time.Duration
+
+	tick   time.Duration
+	timers *timerWheel
+
+	duplicatePolicy DuplicatePolicy
+
+	queued   map[int]queuedTask
+	counters counters
+
+	// held is the number of Run workers holding the task, a requeued
+	// task may be taken by another worker before the first one is done
+	held map[int]int
+}
+
+func NewScheduler(options ...Option) *Scheduler {
+	s := &Scheduler{
+		added:  make(chan struct{}),
+		clock:  realClock{},
+		tick:   defaultTick,
+		queued: make(map[int]queuedTask),
+		held:   make(map[int]int),
+		counters: counters{
+			waitTime: newHistogram(waitTimeBounds),
+		},
+	}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	if s.policy == nil {
+		s.policy = NewPriorityPolicy(s.agingInterval)
+	}
+	s.timers = newTimerWheel(s.tick, s.clock.Now())
+
+	return s
+}
+
+// AddTask adds the task to the queue. If the task with the same identifier
+// is already queued, the duplicate policy decides, by default the task is
+// rejected with ErrDuplicateTask.
+func (s *Scheduler) AddTask(task Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.add(task)
+}
+
+func (s *Scheduler) add(task Task) error {
+	if s.policy.Contains(task.Identifier) {
+		if s.duplicatePolicy == DuplicateReject {
+			return ErrDuplicateTask
+		}
+		s.remove(task.Identifier)
+	}
+
+	s.push(task)
+	return nil
+}
+
+func (s *Scheduler) push(task Task) {
+	now := s.clock.Now()
+	s.policy.Push(task, now)
+	s.enqueued(task, now)
+	s.notify()
+}
+
+func (s *Scheduler) enqueued(task Task, now time.Time) {
+	s.queued[task.Identifier] = queuedTask{priority: task.Priority, enqueuedAt: now}
+	s.counters.enqueued++
 }
 
-func NewScheduler() Scheduler {
-	return Scheduler{
-		tasks: NewTaskQueue(),
+func (s *Scheduler) remove(taskID int) bool {
+	if _, ok := s.policy.Remove(taskID); !ok {
+		return false
 	}
+
+	delete(s.queued, taskID)
+	s.counters.removed++
+
+	return true
+}
+
+func (s *Scheduler) notify() {
+	close(s.added)
+	s.added = make(chan struct{})
 }
 
-func (s *Scheduler) AddTask(task Task) {
-	heap.Push(s.tasks, task)
+// fireTimers moves the tasks which time has come into the queue.
+// An occurrence of a recurring task is skipped if the previous one is
+// still in the queue. A delayed task is added by the duplicate policy,
+// a rejected one is counted in Stats.Rejected.
+func (s *Scheduler) fireTimers() {
+	s.timers.advance(s.clock.Now(), func(task Task, recurring bool) {
+		switch {
+		case !recurring:
+			if s.add(task) != nil {
+				s.counters.rejected++
+			}
+		case !s.policy.Contains(task.Identifier):
+			s.push(task)
+		}
+	})
+}
+
+// ScheduleAt adds the task to the queue at the given moment,
+// a task in the past is added right away as by AddTask. Scheduling
+// a task with the identifier of an already scheduled one replaces it.
+func (s *Scheduler) ScheduleAt(task Task, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fireTimers()
+	if !s.timers.add(task, at, 0) {
+		return s.add(task)
+	}
+
+	return nil
+}
+
+// ScheduleEvery adds the task to the queue every interval starting
+// one interval from now, until it is cancelled.
+func (s *Scheduler) ScheduleEvery(task Task, interval time.Duration) error {
+	if interval <= 0 {
+		return ErrInvalidInterval
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fireTimers()
+	s.timers.add(task, s.clock.Now().Add(interval), interval)
+
+	return nil
+}
+
+// Cancel stops the delayed or recurring task with the given identifier.
+// It reports whether the task was scheduled. Occurrences already moved
+// to the queue are not affected.
+func (s *Scheduler) Cancel(taskID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.timers.remove(taskID)
+}
+
+// RemoveTask removes the queued task, it reports whether the task was queued.
+func (s *Scheduler) RemoveTask(taskID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fireTimers()
+	return s.remove(taskID)
+}
+
+// PeekTask returns the task GetTask would take now without taking it.
+func (s *Scheduler) PeekTask() (Task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fireTimers()
+	return s.policy.Peek(s.clock.Now())
+}
+
+func (s *Scheduler) ContainsTask(taskID int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fireTimers()
+	return s.policy.Contains(taskID)
 }
 
 func (s *Scheduler) ChangeTaskPriority(taskID int, newPriority int) {
-	s.tasks.UpdatePriority(taskID, newPriority)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fireTimers()
+	if !s.policy.ChangePriority(taskID, newPriority, s.clock.Now()) {
+		return
+	}
+
+	task := s.queued[taskID]
+	task.priority = newPriority
+	s.queued[taskID] = task
+	s.counters.reprioritized++
+}
+
+// TimeSlice returns how long the taken task may run before it has to be
+// given back by Requeue. It is 0, i.e. unlimited, unless the policy is
+// a FeedbackPolicy.
+func (s *Scheduler) TimeSlice(taskID int) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if policy, ok := s.policy.(FeedbackPolicy); ok {
+		return policy.TimeSlice(taskID)
+	}
+	return 0
 }
 
-func (s *Scheduler) GetTask() Task {
-	if s.tasks.Len() > 0 {
-		return heap.Pop(s.tasks).(Task)
+// Requeue gives back the taken task which ran for used time and is not
+// finished yet. Feedback policies use the time to reorder the task,
+// other policies add it as AddTask does.
+func (s *Scheduler) Requeue(task Task, used time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	policy, ok := s.policy.(FeedbackPolicy)
+	if !ok {
+		return s.add(task)
+	}
+
+	if policy.Contains(task.Identifier) {
+		return ErrDuplicateTask
 	}
-	return Task{}
+
+	now := s.clock.Now()
+	policy.Requeue(task, used, now)
+	s.enqueued(task, now)
+	s.notify()
+
+	return nil
+}
+
+// Finish tells the policy that the taken task is done, it is needed only
+// for tasks taken by GetTask, Run finishes the tasks it takes itself.
+func (s *Scheduler) Finish(taskID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if policy, ok := s.policy.(FeedbackPolicy); ok && !policy.Contains(taskID) {
+		policy.Finish(taskID)
+	}
+}
+
+// release is Finish for the task taken by a Run worker, the task is not
+// finished while it is queued again or held by another worker
+func (s *Scheduler) release(taskID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.held[taskID]--
+	if s.held[taskID] > 0 {
+		return
+	}
+	delete(s.held, taskID)
+
+	if policy, ok := s.policy.(FeedbackPolicy); ok && !policy.Contains(taskID) {
+		policy.Finish(taskID)
+	}
+}
+
+// GetTask takes the next task according to the policy, by default the task
+// with the highest priority. If there are no tasks it blocks until a task
+// is added or the context is done, in the latter case the context error is
+// returned. The task is returned with the priority set by the user,
+// regardless of the one gained by aging.
+func (s *Scheduler) GetTask(ctx context.Context) (Task, error) {
+	return s.getTask(ctx, false)
+}
+
+// getTask is GetTask, a held task is counted as held by a Run worker
+func (s *Scheduler) getTask(ctx context.Context, hold bool) (Task, error) {
+	for {
+		s.mu.Lock()
+		s.fireTimers()
+		now := s.clock.Now()
+		if task, ok := s.policy.Pop(now); ok {
+			s.counters.dequeued++
+			s.counters.waitTime.observe(now.Sub(s.queued[task.Identifier].enqueuedAt))
+			delete(s.queued, task.Identifier)
+			if hold {
+				s.held[task.Identifier]++
+			}
+
+			s.mu.Unlock()
+			return task, nil
+		}
+		added := s.added
+
+		// wake up when the next timer is due, nil channel blocks forever
+		var due <-chan time.Time
+		if next, ok := s.timers.next(); ok {
+			due = s.clock.After(next.Sub(s.clock.Now()))
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Task{}, ctx.Err()
+		case <-added:
+		case <-due:
+		}
+	}
+}
+
+// Stats returns a snapshot of the scheduler state and counters.
+func (s *Scheduler) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fireTimers()
+
+	byPriority := make(map[int]int)
+	for _, task := range s.queued {
+		byPriority[task.priority]++
+	}
+
+	return Stats{
+		Depth:         s.policy.Len(),
+		ByPriority:    byPriority,
+		Scheduled:     s.timers.Len(),
+		Enqueued:      s.counters.enqueued,
+		Dequeued:      s.counters.dequeued,
+		Removed:       s.counters.removed,
+		Reprioritized: s.counters.reprioritized,
+		Rejected:      s.counters.rejected,
+		WaitTime:      s.counters.waitTime.clone(),
+	}
+}
+
+// Dump returns the queued tasks in the order they would be taken,
+// the queue is not changed.
+func (s *Scheduler) Dump() []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fireTimers()
+	return s.policy.Tasks(s.clock.Now())
+}
+
+// Run starts the given number of workers, each of them takes tasks
+// by the policy and passes them to the handler. It blocks until
+// the context is done and all workers finish their current tasks.
+// At least one worker is started, even if workers is less than 1.
+func (s *Scheduler) Run(ctx context.Context, workers int, handler func(ctx context.Context, task Task)) {
+	workers = max(workers, 1)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				task, err := s.getTask(ctx, true)
+				if err != nil {
+					return
+				}
+				handler(ctx, task)
+				s.release(task.Identifier)
+			}
+		}()
+	}
+
+	wg.Wait()
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+)
+
+// SimulatedTask is a task of the simulation workload, it arrives
+// at the given offset from the start and needs Work time to complete.
+type SimulatedTask struct {
+	Task
+	Arrival time.Duration
+	Work    time.Duration
+}
+
+// GroupReport is a result of the simulation for a task group. Wait is
+// the time a task spent in the queue, i.e. completion - arrival - work.
+type GroupReport struct {
+	Completed int
+	// Throughput is the number of tasks completed per second
+	Throughput float64
+	WaitP50    time.Duration
+	WaitP90    time.Duration
+	WaitP99    time.Duration
+	WaitMax    time.Duration
+}
+
+type SimulationReport struct {
+	// Duration is the simulated time till the last task is completed
+	Duration time.Duration
+	Groups   map[string]GroupReport
+}
+
+type simulatedWorker struct {
+	task      Task
+	busy      bool
+	ran       time.Duration
+	releaseAt time.Duration
+}
+
+// Simulate runs the workload through the policy on the given number of
+// workers in virtual time and reports per group throughput and wait time
+// percentiles. A task taken from a FeedbackPolicy runs for at most its
+// time slice and is requeued if it is not completed. Task identifiers
+// have to be unique. The run is fully deterministic for deterministic
+// policies.
+func Simulate(policy Policy, workload []SimulatedTask, workers int) SimulationReport {
+	start := time.Time{}
+	feedback, _ := policy.(FeedbackPolicy)
+
+	arrivals := slices.Clone(workload)
+	slices.SortStableFunc(arrivals, func(a, b SimulatedTask) int {
+		return cmp.Compare(a.Arrival, b.Arrival)
+	})
+
+	tasks := make(map[int]SimulatedTask, len(workload))
+	remaining := make(map[int]time.Duration, len(workload))
+	waits := make(map[string][]time.Duration)
+
+	pool := make([]simulatedWorker, workers)
+	next := 0
+	var now time.Duration
+
+	for completed := 0; completed < len(arrivals); {
+		// release workers whose tasks ran out of work or time slice
+		for i := range pool {
+			w := &pool[i]
+			if !w.busy || w.releaseAt != now {
+				continue
+			}
+			w.busy = false
+
+			id := w.task.Identifier
+			remaining[id] -= w.ran
+			if remaining[id] > 0 {
+				feedback.Requeue(w.task, w.ran, start.Add(now))
+				continue
+			}
+
+			if feedback != nil {
+				feedback.Finish(id)
+			}
+
+			task := tasks[id]
+			waits[task.Group] = append(waits[task.Group], now-task.Arrival-task.Work)
+			completed++
+		}
+
+		for ; next < len(arrivals) && arrivals[next].Arrival <= now; next++ {
+			task := arrivals[next]
+			tasks[task.Identifier] = task
+			remaining[task.Identifier] = task.Work
+			policy.Push(task.Task, start.Add(now))
+		}
+
+		for i := range pool {
+			w := &pool[i]
+			if w.busy {
+				continue
+			}
+
+			task, ok := policy.Pop(start.Add(now))
+			if !ok {
+				break
+			}
+
+			ran := remaining[task.Identifier]
+			if feedback != nil {
+				if slice := feedback.TimeSlice(task.Identifier); slice > 0 {
+					ran = min(ran, slice)
+				}
+			}
+
+			*w = simulatedWorker{task: task, busy: true, ran: ran, releaseAt: now + ran}
+		}
+
+		// jump to the next event
+		nextEvent := time.Duration(math.MaxInt64)
+		if next < len(arrivals) {
+			nextEvent = arrivals[next].Arrival
+		}
+		for _, w := range pool {
+			if w.busy {
+				nextEvent = min(nextEvent, w.releaseAt)
+			}
+		}
+		if nextEvent == math.MaxInt64 {
+			break
+		}
+		now = nextEvent
+	}
+
+	report := SimulationReport{
+		Duration: now,
+		Groups:   make(map[string]GroupReport, len(waits)),
+	}
+
+	for group, groupWaits := range waits {
+		slices.Sort(groupWaits)
+
+		var throughput float64
+		if now > 0 {
+			throughput = float64(len(groupWaits)) / now.Seconds()
+		}
+
+		report.Groups[group] = GroupReport{
+			Completed:  len(groupWaits),
+			Throughput: throughput,
+			WaitP50:    percentile(groupWaits, 0.5),
+			WaitP90:    percentile(groupWaits, 0.9),
+			WaitP99:    percentile(groupWaits, 0.99),
+			WaitMax:    groupWaits[len(groupWaits)-1],
+		}
+	}
+
+	return report
+}
+
+// percentile returns the nearest-rank percentile of the sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	return sorted[max(rank, 0)]
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// waitTimeBounds are upper bounds of the wait time histogram buckets
+var waitTimeBounds = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram counts observed durations by buckets. Counts[i] is the number
+// of durations less than or equal to Bounds[i] and greater than the
+// previous bound, the last extra bucket counts durations above all bounds.
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []int
+	Count  int
+	Sum    time.Duration
+}
+
+func newHistogram(bounds []time.Duration) Histogram {
+	return Histogram{
+		Bounds: bounds,
+		Counts: make([]int, len(bounds)+1),
+	}
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	i, _ := slices.BinarySearch(h.Bounds, d)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += d
+}
+
+// Mean returns the mean observed duration, 0 if there are no observations.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+func (h Histogram) clone() Histogram {
+	h.Bounds = slices.Clone(h.Bounds)
+	h.Counts = slices.Clone(h.Counts)
+	return h
+}
+
+// Stats is a snapshot of the scheduler state and counters.
+type Stats struct {
+	// Depth is the number of queued tasks
+	Depth int
+	// ByPriority is the number of queued tasks per priority set by the user
+	ByPriority map[int]int
+	// Scheduled is the number of delayed and recurring tasks
+	// waiting for their time
+	Scheduled     int
+	Enqueued      int
+	Dequeued      int
+	Removed       int
+	Reprioritized int
+	// Rejected is the number of delayed tasks rejected by
+	// DuplicateReject policy when their time came
+	Rejected int
+	// WaitTime is the histogram of time taken tasks spent in the queue
+	WaitTime Histogram
+}
+
+// queuedTask keeps what scheduler knows about the queued task for stats
+type queuedTask struct {
+	priority   int
+	enqueuedAt time.Time
+}
+
+// counters are the scheduler counters exposed by Stats
+type counters struct {
+	enqueued      int
+	dequeued      int
+	removed       int
+	reprioritized int
+	rejected      int
+	waitTime      Histogram
+}
@@ -7,6 +7,9 @@ import (
 type Task struct {
 	Identifier int
 	Priority   int
+	// Group is a tenant the task belongs to, FairPolicy shares
+	// the scheduler between groups by their weights
+	Group string
 }
 
 type TaskQueue struct {
@@ -38,7 +41,7 @@ func (q *TaskQueue) Swap(i, j int) {
 func (q *TaskQueue) Push(t any) {
 	task := t.(Task)
 	q.items = append(q.items, task)
-	q.id2Idx[task.Identifier] = len(q.items)
+	q.id2Idx[task.Identifier] = len(q.items) - 1
 }
 
 func (q *TaskQueue) Pop() any {
@@ -58,3 +61,25 @@ func (q *TaskQueue) UpdatePriority(id int, priority int) {
 	q.items[idx].Priority = priority
 	heap.Fix(q, idx)
 }
+
+func (q *TaskQueue) Contains(id int) bool {
+	_, ok := q.id2Idx[id]
+	return ok
+}
+
+// Peek returns the task with the highest priority without removing it.
+func (q *TaskQueue) Peek() (Task, bool) {
+	if len(q.items) == 0 {
+		return Task{}, false
+	}
+	return q.items[0], true
+}
+
+// Remove removes the task with the given identifier from the queue.
+func (q *TaskQueue) Remove(id int) (Task, bool) {
+	idx, ok := q.id2Idx[id]
+	if !ok {
+		return Task{}, false
+	}
+	return heap.Remove(q, idx).(Task), true
+}
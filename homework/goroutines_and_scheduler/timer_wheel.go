@@ -0,0 +1,201 @@
+package main
+
+import (
+	"time"
+)
+
+const (
+	wheelLevels   = 4
+	wheelSlotBits = 6
+	wheelSlots    = 1 << wheelSlotBits
+	wheelSlotMask = wheelSlots - 1
+)
+
+// timerEntry is a task waiting in the timer wheel. Recurring entries
+// have positive interval and are added back after every fire.
+type timerEntry struct {
+	task     Task
+	due      uint64
+	interval time.Duration
+}
+
+// timerWheel is a hierarchical timing wheel. Level 0 has a slot per tick,
+// every next level has a slot per full round of the previous one. An entry
+// is placed on the lowest level where its due tick shares the round with
+// the current tick and moves down (cascades) when the wheel reaches it.
+// Adding and removing is O(1), advancing is O(1) per tick and entry move.
+type timerWheel struct {
+	tick    time.Duration
+	start   time.Time
+	current uint64
+	slots   [wheelLevels][wheelSlots]map[int]*timerEntry
+	// counts keeps number of entries on every level
+	counts [wheelLevels]int
+	// where keeps level and slot of the entry by task identifier
+	where map[int][2]int
+}
+
+func newTimerWheel(tick time.Duration, start time.Time) *timerWheel {
+	return &timerWheel{
+		tick:  tick,
+		start: start,
+		where: make(map[int][2]int),
+	}
+}
+
+func (w *timerWheel) Len() int {
+	return len(w.where)
+}
+
+// ticks returns the number of the last tick elapsed at the moment
+func (w *timerWheel) ticks(now time.Time) uint64 {
+	if !now.After(w.start) {
+		return 0
+	}
+	return uint64(now.Sub(w.start) / w.tick)
+}
+
+// durationTicks returns the number of ticks covering the duration
+func (w *timerWheel) durationTicks(d time.Duration) uint64 {
+	return uint64((d + w.tick - 1) / w.tick)
+}
+
+// dueTick returns the first tick which is not earlier than the moment
+func (w *timerWheel) dueTick(at time.Time) uint64 {
+	if !at.After(w.start) {
+		return 0
+	}
+	return w.durationTicks(at.Sub(w.start))
+}
+
+func (w *timerWheel) place(e *timerEntry) {
+	level := 0
+	for ; level < wheelLevels-1; level++ {
+		shift := wheelSlotBits * (level + 1)
+		if e.due>>shift == w.current>>shift {
+			break
+		}
+	}
+
+	shift := wheelSlotBits * level
+	slot := int(e.due>>shift) & wheelSlotMask
+	if level == wheelLevels-1 && e.due>>(shift+wheelSlotBits) != w.current>>(shift+wheelSlotBits) {
+		// out of the wheel range, park in the first slot of the top level,
+		// it is free during the round and cascades at the start of the next one
+		slot = 0
+	}
+
+	if w.slots[level][slot] == nil {
+		w.slots[level][slot] = make(map[int]*timerEntry)
+	}
+	w.slots[level][slot][e.task.Identifier] = e
+	w.where[e.task.Identifier] = [2]int{level, slot}
+	w.counts[level]++
+}
+
+// add puts the task into the wheel to fire at the given moment, a task
+// with the same identifier is replaced. It returns false if the moment
+// has already come, the task is not added then.
+func (w *timerWheel) add(task Task, at time.Time, interval time.Duration) bool {
+	w.remove(task.Identifier)
+
+	due := w.dueTick(at)
+	if due <= w.current {
+		return false
+	}
+
+	w.place(&timerEntry{task: task, due: due, interval: interval})
+	return true
+}
+
+func (w *timerWheel) remove(id int) bool {
+	pos, ok := w.where[id]
+	if !ok {
+		return false
+	}
+
+	delete(w.slots[pos[0]][pos[1]], id)
+	delete(w.where, id)
+	w.counts[pos[0]]--
+	return true
+}
+
+// advance moves the wheel to the moment and calls fire for every entry
+// which became due, in order of their due ticks. Recurring entries are
+// added back to fire after their interval.
+func (w *timerWheel) advance(now time.Time, fire func(task Task, recurring bool)) {
+	to := w.ticks(now)
+	for w.current < to {
+		if len(w.where) == 0 {
+			w.current = to
+			return
+		}
+
+		// skip the rest of the rounds of empty lower levels
+		for level := 0; level < wheelLevels-1 && w.counts[level] == 0; level++ {
+			w.current = min(w.current|(1<<(wheelSlotBits*(level+1))-1), to)
+		}
+		if w.current == to {
+			return
+		}
+
+		w.current++
+
+		for level := 1; level < wheelLevels; level++ {
+			shift := wheelSlotBits * level
+			if w.current&(1<<shift-1) != 0 {
+				break
+			}
+
+			slot := int(w.current>>shift) & wheelSlotMask
+			entries := w.slots[level][slot]
+			w.slots[level][slot] = nil
+			w.counts[level] -= len(entries)
+			for _, e := range entries {
+				w.place(e)
+			}
+		}
+
+		slot := int(w.current) & wheelSlotMask
+		entries := w.slots[0][slot]
+		w.slots[0][slot] = nil
+		w.counts[0] -= len(entries)
+		for id, e := range entries {
+			delete(w.where, id)
+			fire(e.task, e.interval > 0)
+
+			if e.interval > 0 {
+				e.due += max(w.durationTicks(e.interval), 1)
+				w.place(e)
+			}
+		}
+	}
+}
+
+// next returns the moment the wheel has to be advanced at to fire
+// the earliest entry or to cascade the upper levels
+func (w *timerWheel) next() (time.Time, bool) {
+	if len(w.where) == 0 {
+		return time.Time{}, false
+	}
+
+	if w.counts[0] > 0 {
+		// all entries of level 0 are due in the current round
+		tick := w.current + 1
+		for len(w.slots[0][tick&wheelSlotMask]) == 0 {
+			tick++
+		}
+		return w.start.Add(time.Duration(tick) * w.tick), true
+	}
+
+	level := 1
+	for level < wheelLevels-1 && w.counts[level] == 0 {
+		level++
+	}
+
+	// the start of the next slot of the level, where it cascades
+	shift := wheelSlotBits * level
+	tick := (w.current>>shift + 1) << shift
+
+	return w.start.Add(time.Duration(tick) * w.tick), true
+}
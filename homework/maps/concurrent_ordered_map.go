@@ -0,0 +1,228 @@
+package main
+
+import (
+	"cmp"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const skipListMaxLevel = 32
+
+// skipNode is a node of the lazy skip list. Node is logically removed
+// once marked and logically present once fully linked, mu guards
+// changes of the next pointers of the node.
+type skipNode[K cmp.Ordered, V any] struct {
+	key         K
+	value       atomic.Pointer[V]
+	next        []atomic.Pointer[skipNode[K, V]]
+	marked      atomic.Bool
+	fullyLinked atomic.Bool
+	mu          sync.Mutex
+}
+
+func newSkipNode[K cmp.Ordered, V any](key K, value *V, level int) *skipNode[K, V] {
+	n := &skipNode[K, V]{
+		key:  key,
+		next: make([]atomic.Pointer[skipNode[K, V]], level+1),
+	}
+	n.value.Store(value)
+	return n
+}
+
+func (n *skipNode[K, V]) topLevel() int {
+	return len(n.next) - 1
+}
+
+// ConcurrentOrderedMap is an ordered map safe for concurrent use.
+// It is backed by a lazy skip list (Herlihy, Lev, Luchangco, Shavit):
+// Contains and ForEach are lock-free, Insert and Erase lock only
+// the predecessors of the changed node.
+type ConcurrentOrderedMap[K cmp.Ordered, V any] struct {
+	size atomic.Int64
+	head *skipNode[K, V]
+}
+
+func NewConcurrentOrderedMap[K cmp.Ordered, V any]() *ConcurrentOrderedMap[K, V] {
+	var zeroKey K
+	return &ConcurrentOrderedMap[K, V]{
+		head: newSkipNode[K, V](zeroKey, nil, skipListMaxLevel-1),
+	}
+}
+
+// randomLevel returns level with geometric distribution, p = 1/2
+func (m *ConcurrentOrderedMap[K, V]) randomLevel() int {
+	return min(bits.TrailingZeros64(rand.Uint64()), skipListMaxLevel-1)
+}
+
+// find fills predecessors and successors of the key on every level,
+// nil successor stands for the end of the list. It returns the highest
+// level where the key was found or -1.
+func (m *ConcurrentOrderedMap[K, V]) find(k K, preds, succs *[skipListMaxLevel]*skipNode[K, V]) int {
+	found := -1
+	pred := m.head
+	for level := skipListMaxLevel - 1; level >= 0; level-- {
+		current := pred.next[level].Load()
+		for current != nil && current.key < k {
+			pred = current
+			current = pred.next[level].Load()
+		}
+
+		if found == -1 && current != nil && current.key == k {
+			found = level
+		}
+
+		preds[level] = pred
+		succs[level] = current
+	}
+
+	return found
+}
+
+// lockPreds locks distinct predecessors up to the top level while
+// valid reports that the links are still intact. It returns the locked
+// nodes and whether all levels are valid.
+func (m *ConcurrentOrderedMap[K, V]) lockPreds(
+	preds *[skipListMaxLevel]*skipNode[K, V],
+	topLevel int,
+	valid func(level int) bool,
+) ([]*skipNode[K, V], bool) {
+	locked := make([]*skipNode[K, V], 0, topLevel+1)
+	for level := 0; level <= topLevel; level++ {
+		pred := preds[level]
+		if len(locked) == 0 || locked[len(locked)-1] != pred {
+			pred.mu.Lock()
+			locked = append(locked, pred)
+		}
+
+		if pred.marked.Load() || !valid(level) {
+			return locked, false
+		}
+	}
+
+	return locked, true
+}
+
+func unlockAll[K cmp.Ordered, V any](locked []*skipNode[K, V]) {
+	for _, n := range locked {
+		n.mu.Unlock()
+	}
+}
+
+// Insert adds the key-value pair to the map.
+// If the key is already present, its value is replaced.
+func (m *ConcurrentOrderedMap[K, V]) Insert(key K, value V) {
+	topLevel := m.randomLevel()
+
+	var preds, succs [skipListMaxLevel]*skipNode[K, V]
+	for {
+		if found := m.find(key, &preds, &succs); found != -1 {
+			n := succs[found]
+			if n.marked.Load() {
+				// concurrent erase, wait until it is unlinked
+				runtime.Gosched()
+				continue
+			}
+
+			for !n.fullyLinked.Load() {
+				runtime.Gosched()
+			}
+			n.value.Store(&value)
+			return
+		}
+
+		locked, ok := m.lockPreds(&preds, topLevel, func(level int) bool {
+			succ := succs[level]
+			return (succ == nil || !succ.marked.Load()) && preds[level].next[level].Load() == succ
+		})
+		if !ok {
+			unlockAll(locked)
+			continue
+		}
+
+		n := newSkipNode(key, &value, topLevel)
+		for level := 0; level <= topLevel; level++ {
+			n.next[level].Store(succs[level])
+		}
+		for level := 0; level <= topLevel; level++ {
+			preds[level].next[level].Store(n)
+		}
+		n.fullyLinked.Store(true)
+		m.size.Add(1)
+
+		unlockAll(locked)
+		return
+	}
+}
+
+// Erase removes the key-value pair with the given key from the map.
+// It is a no-op if the key is not present in the map.
+func (m *ConcurrentOrderedMap[K, V]) Erase(key K) {
+	var (
+		victim       *skipNode[K, V]
+		preds, succs [skipListMaxLevel]*skipNode[K, V]
+	)
+
+	for {
+		found := m.find(key, &preds, &succs)
+
+		if victim == nil {
+			if found == -1 {
+				return
+			}
+
+			n := succs[found]
+			if !n.fullyLinked.Load() || n.topLevel() != found || n.marked.Load() {
+				return
+			}
+
+			n.mu.Lock()
+			if n.marked.Load() {
+				n.mu.Unlock()
+				return
+			}
+			n.marked.Store(true)
+			victim = n
+		}
+
+		topLevel := victim.topLevel()
+		locked, ok := m.lockPreds(&preds, topLevel, func(level int) bool {
+			return preds[level].next[level].Load() == victim
+		})
+		if !ok {
+			unlockAll(locked)
+			continue
+		}
+
+		for level := topLevel; level >= 0; level-- {
+			preds[level].next[level].Store(victim.next[level].Load())
+		}
+		m.size.Add(-1)
+
+		victim.mu.Unlock()
+		unlockAll(locked)
+		return
+	}
+}
+
+func (m *ConcurrentOrderedMap[K, V]) Contains(key K) bool {
+	var preds, succs [skipListMaxLevel]*skipNode[K, V]
+	found := m.find(key, &preds, &succs)
+	return found != -1 && succs[found].fullyLinked.Load() && !succs[found].marked.Load()
+}
+
+func (m *ConcurrentOrderedMap[K, V]) Size() int {
+	return int(m.size.Load())
+}
+
+// ForEach calls action in key order for every entry present in the map.
+// It doesn't block writers, so concurrent changes may or may not be observed.
+func (m *ConcurrentOrderedMap[K, V]) ForEach(action func(K, V)) {
+	for n := m.head.next[0].Load(); n != nil; n = n.next[0].Load() {
+		if n.fullyLinked.Load() && !n.marked.Load() {
+			action(n.key, *n.value.Load())
+		}
+	}
+}
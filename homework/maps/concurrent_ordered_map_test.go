@@ -0,0 +1,142 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -race -v .
+func TestConcurrentOrderedMap(t *testing.T) {
+	data := NewConcurrentOrderedMap[int, int]()
+	assert.Zero(t, data.Size())
+
+	data.Insert(10, 10)
+	data.Insert(5, 5)
+	data.Insert(15, 15)
+	data.Insert(2, 2)
+	data.Insert(4, 4)
+	data.Insert(12, 12)
+	data.Insert(14, 14)
+
+	assert.Equal(t, 7, data.Size())
+	assert.True(t, data.Contains(4))
+	assert.True(t, data.Contains(12))
+	assert.False(t, data.Contains(3))
+	assert.False(t, data.Contains(13))
+
+	var keys []int
+	data.ForEach(func(key, _ int) {
+		keys = append(keys, key)
+	})
+	assert.Equal(t, []int{2, 4, 5, 10, 12, 14, 15}, keys)
+
+	data.Erase(15)
+	data.Erase(14)
+	data.Erase(2)
+	data.Erase(3)
+
+	assert.Equal(t, 4, data.Size())
+	assert.True(t, data.Contains(4))
+	assert.True(t, data.Contains(12))
+	assert.False(t, data.Contains(2))
+	assert.False(t, data.Contains(14))
+
+	keys = nil
+	data.ForEach(func(key, _ int) {
+		keys = append(keys, key)
+	})
+	assert.Equal(t, []int{4, 5, 10, 12}, keys)
+
+	data.Insert(4, 40)
+	assert.Equal(t, 4, data.Size())
+	data.ForEach(func(key, value int) {
+		if key == 4 {
+			assert.Equal(t, 40, value)
+		}
+	})
+}
+
+func TestConcurrentOrderedMapStress(t *testing.T) {
+	const (
+		goroutines = 8
+		perWorker  = 2000
+	)
+
+	t.Run("Concurrent Disjoint Inserts And Erases", func(t *testing.T) {
+		m := NewConcurrentOrderedMap[int, int]()
+
+		var wg sync.WaitGroup
+		wg.Add(goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func() {
+				defer wg.Done()
+				for i := g; i < goroutines*perWorker; i += goroutines {
+					m.Insert(i, i)
+				}
+				for i := g; i < goroutines*perWorker; i += 2 * goroutines {
+					m.Erase(i)
+				}
+			}()
+		}
+		wg.Wait()
+
+		var keys []int
+		m.ForEach(func(k, v int) {
+			assert.Equal(t, k, v)
+			keys = append(keys, k)
+		})
+
+		assert.Equal(t, goroutines*perWorker/2, m.Size())
+		assert.Equal(t, m.Size(), len(keys))
+		assert.True(t, slices.IsSorted(keys))
+		for _, k := range keys {
+			assert.True(t, (k/goroutines)%2 == 1)
+		}
+	})
+
+	t.Run("Concurrent Same Keys", func(t *testing.T) {
+		m := NewConcurrentOrderedMap[int, int]()
+		const keysNumber = 64
+
+		var wg sync.WaitGroup
+		wg.Add(goroutines)
+		for g := 0; g < goroutines; g++ {
+			go func() {
+				defer wg.Done()
+				for i := 0; i < perWorker; i++ {
+					k := (i * (g + 1)) % keysNumber
+					switch i % 3 {
+					case 0, 1:
+						m.Insert(k, g)
+					case 2:
+						m.Erase(k)
+					}
+					_ = m.Contains(k)
+				}
+			}()
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				var keys []int
+				m.ForEach(func(k, _ int) {
+					keys = append(keys, k)
+				})
+				assert.True(t, slices.IsSorted(keys))
+			}
+		}()
+		wg.Wait()
+
+		count := 0
+		m.ForEach(func(k, _ int) {
+			count++
+			assert.True(t, m.Contains(k))
+		})
+		assert.Equal(t, count, m.Size())
+	})
+}
@@ -2,36 +2,151 @@ package main
 
 import (
 	"cmp"
+	"errors"
+	"reflect"
 )
 
-type node[K cmp.Ordered, V any] struct {
+// node is an AVL tree node. height is the height of the subtree
+// rooted at the node, a leaf has height 1. size is the number of
+// nodes in the subtree, it is used for rank and select. version is
+// the version of the map the node was created in, nodes of older
+// versions may be shared with snapshots and are never modified.
+type node[K, V any] struct {
 	key         K
 	value       V
+	height      int
+	size        int
+	version     uint64
 	left, right *node[K, V]
 }
 
-type OrderedMap[K cmp.Ordered, V any] struct {
-	size int
-	root *node[K, V]
+func (n *node[K, V]) getHeight() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *node[K, V]) getSize() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func (n *node[K, V]) balanceFactor() int {
+	return n.left.getHeight() - n.right.getHeight()
+}
+
+func (n *node[K, V]) update() {
+	n.height = 1 + max(n.left.getHeight(), n.right.getHeight())
+	n.size = 1 + n.left.getSize() + n.right.getSize()
+}
+
+// OrderedMap is a map with keys kept in sorted order.
+// It is backed by an AVL tree, so height of the tree is O(log n).
+// Keys are ordered by the comparator the map is created with. The zero
+// value orders keys of integer, float and string kinds naturally, it
+// panics with ErrNoComparator on insert of keys of other kinds.
+type OrderedMap[K, V any] struct {
+	size    int
+	version uint64
+	compare func(a, b K) int
+	root    *node[K, V]
+}
+
+// mutable returns the node itself if it belongs to the current version
+// of the map, otherwise its copy (path copying), so nodes shared with
+// snapshots stay untouched
+func (m *OrderedMap[K, V]) mutable(current *node[K, V]) *node[K, V] {
+	if current.version == m.version {
+		return current
+	}
+
+	clone := *current
+	clone.version = m.version
+
+	return &clone
+}
+
+func (m *OrderedMap[K, V]) rotateRight(current *node[K, V]) *node[K, V] {
+	current = m.mutable(current)
+	left := m.mutable(current.left)
+	current.left = left.right
+	left.right = current
+
+	current.update()
+	left.update()
+
+	return left
+}
+
+func (m *OrderedMap[K, V]) rotateLeft(current *node[K, V]) *node[K, V] {
+	current = m.mutable(current)
+	right := m.mutable(current.right)
+	current.right = right.left
+	right.left = current
+
+	current.update()
+	right.update()
+
+	return right
+}
+
+// balance restores AVL invariant for the node which subtrees
+// heights differ at most by 2
+func (m *OrderedMap[K, V]) balance(current *node[K, V]) *node[K, V] {
+	current.update()
+
+	switch bf := current.balanceFactor(); {
+	case bf > 1:
+		if current.left.balanceFactor() < 0 {
+			current.left = m.rotateLeft(current.left)
+		}
+		return m.rotateRight(current)
+	case bf < -1:
+		if current.right.balanceFactor() > 0 {
+			current.right = m.rotateRight(current.right)
+		}
+		return m.rotateLeft(current)
+	}
+
+	return current
 }
 
 func (m *OrderedMap[K, V]) put(current *node[K, V], k K, v V) *node[K, V] {
 	if current == nil {
-		return &node[K, V]{key: k, value: v}
+		m.size++
+		return &node[K, V]{key: k, value: v, height: 1, size: 1, version: m.version}
 	}
 
-	if k == current.key {
+	current = m.mutable(current)
+
+	c := m.compare(k, current.key)
+
+	if c == 0 {
 		current.value = v
 		return current
 	}
 
-	if k < current.key {
+	if c < 0 {
 		current.left = m.put(current.left, k, v)
 	} else {
 		current.right = m.put(current.right, k, v)
 	}
 
-	return current
+	return m.balance(current)
+}
+
+func (m *OrderedMap[K, V]) delMin(current *node[K, V]) *node[K, V] {
+	if current.left == nil {
+		return current.right
+	}
+
+	current = m.mutable(current)
+	current.left = m.delMin(current.left)
+
+	return m.balance(current)
 }
 
 func (m *OrderedMap[K, V]) del(current *node[K, V], k K) *node[K, V] {
@@ -39,16 +154,22 @@ func (m *OrderedMap[K, V]) del(current *node[K, V], k K) *node[K, V] {
 		return nil
 	}
 
-	if k < current.key {
+	current = m.mutable(current)
+
+	c := m.compare(k, current.key)
+
+	if c < 0 {
 		current.left = m.del(current.left, k)
-		return current
+		return m.balance(current)
 	}
 
-	if k > current.key {
+	if c > 0 {
 		current.right = m.del(current.right, k)
-		return current
+		return m.balance(current)
 	}
 
+	m.size--
+
 	if current.left == nil {
 		return current.right
 	}
@@ -57,16 +178,16 @@ func (m *OrderedMap[K, V]) del(current *node[K, V], k K) *node[K, V] {
 		return current.left
 	}
 
-	// find left with min in right subtree
-	leaf := current
+	// replace with min in right subtree
+	leaf := current.right
 	for leaf.left != nil {
 		leaf = leaf.left
 	}
 
 	current.value, current.key = leaf.value, leaf.key
-	current.right = m.del(current.right, current.key)
+	current.right = m.delMin(current.right)
 
-	return current
+	return m.balance(current)
 }
 
 func (m *OrderedMap[K, V]) has(current *node[K, V], k K) bool {
@@ -74,33 +195,205 @@ func (m *OrderedMap[K, V]) has(current *node[K, V], k K) bool {
 		return false
 	}
 
-	if k == current.key {
+	c := m.compare(k, current.key)
+
+	if c == 0 {
 		return true
 	}
 
-	if k < current.key {
+	if c < 0 {
 		return m.has(current.left, k)
 	}
 
 	return m.has(current.right, k)
 }
 
-func (m *OrderedMap[K, V]) inOrderTraverse(current *node[K, V], fn func(k K, v V)) {
+func (m *OrderedMap[K, V]) get(current *node[K, V], k K) *node[K, V] {
+	for current != nil {
+		c := m.compare(k, current.key)
+
+		switch {
+		case c < 0:
+			current = current.left
+		case c > 0:
+			current = current.right
+		default:
+			return current
+		}
+	}
+
+	return nil
+}
+
+// floor returns node with the greatest key less than or equal to k
+func (m *OrderedMap[K, V]) floor(current *node[K, V], k K) *node[K, V] {
+	if current == nil {
+		return nil
+	}
+
+	c := m.compare(k, current.key)
+
+	if c == 0 {
+		return current
+	}
+
+	if c < 0 {
+		return m.floor(current.left, k)
+	}
+
+	if right := m.floor(current.right, k); right != nil {
+		return right
+	}
+
+	return current
+}
+
+// ceiling returns node with the least key greater than or equal to k
+func (m *OrderedMap[K, V]) ceiling(current *node[K, V], k K) *node[K, V] {
+	if current == nil {
+		return nil
+	}
+
+	c := m.compare(k, current.key)
+
+	if c == 0 {
+		return current
+	}
+
+	if c > 0 {
+		return m.ceiling(current.right, k)
+	}
+
+	if left := m.ceiling(current.left, k); left != nil {
+		return left
+	}
+
+	return current
+}
+
+// rank returns number of keys less than k
+func (m *OrderedMap[K, V]) rank(current *node[K, V], k K) int {
+	if current == nil {
+		return 0
+	}
+
+	c := m.compare(k, current.key)
+
+	if c == 0 {
+		return current.left.getSize()
+	}
+
+	if c < 0 {
+		return m.rank(current.left, k)
+	}
+
+	return 1 + current.left.getSize() + m.rank(current.right, k)
+}
+
+// sel returns node with the i-th smallest key, i is 0-based
+func (m *OrderedMap[K, V]) sel(current *node[K, V], i int) *node[K, V] {
+	if current == nil {
+		return nil
+	}
+
+	leftSize := current.left.getSize()
+
+	if i < leftSize {
+		return m.sel(current.left, i)
+	}
+
+	if i > leftSize {
+		return m.sel(current.right, i-leftSize-1)
+	}
+
+	return current
+}
+
+// rangeTraverse visits in order only the keys within [lo, hi],
+// subtrees that are out of the range are skipped
+func (m *OrderedMap[K, V]) rangeTraverse(current *node[K, V], lo, hi K, fn func(k K, v V)) {
 	if current == nil {
 		return
 	}
 
-	m.inOrderTraverse(current.left, fn)
-	fn(current.key, current.value)
-	m.inOrderTraverse(current.right, fn)
+	cmpLo, cmpHi := m.compare(lo, current.key), m.compare(current.key, hi)
+
+	if cmpLo < 0 {
+		m.rangeTraverse(current.left, lo, hi, fn)
+	}
+
+	if cmpLo <= 0 && cmpHi <= 0 {
+		fn(current.key, current.value)
+	}
+
+	if cmpHi < 0 {
+		m.rangeTraverse(current.right, lo, hi, fn)
+	}
+}
+
+// entry unpacks the node into key-value pair with a presence flag
+func entry[K, V any](n *node[K, V]) (key K, value V, ok bool) {
+	if n == nil {
+		return
+	}
+	return n.key, n.value, true
 }
 
+// NewOrderedMap creates a map ordered by the natural order of the keys.
 func NewOrderedMap[K cmp.Ordered, V any]() OrderedMap[K, V] {
-	return OrderedMap[K, V]{}
+	return NewOrderedMapFunc[K, V](cmp.Compare[K])
+}
+
+// NewOrderedMapFunc creates a map ordered by the comparator, which has to
+// return a negative number when a < b, a positive number when a > b and
+// zero when keys are equal, like cmp.Compare does.
+func NewOrderedMapFunc[K, V any](compare func(a, b K) int) OrderedMap[K, V] {
+	return OrderedMap[K, V]{compare: compare}
+}
+
+// naturalCompare returns the comparator by natural order of the kind
+// of K, nil if the kind is not ordered. It is used by the zero value
+// map, so named types like time.Duration work too.
+func naturalCompare[K any]() func(a, b K) int {
+	switch reflect.TypeFor[K]().Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b K) int {
+			return cmp.Compare(reflect.ValueOf(a).Int(), reflect.ValueOf(b).Int())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(a, b K) int {
+			return cmp.Compare(reflect.ValueOf(a).Uint(), reflect.ValueOf(b).Uint())
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(a, b K) int {
+			return cmp.Compare(reflect.ValueOf(a).Float(), reflect.ValueOf(b).Float())
+		}
+	case reflect.String:
+		return func(a, b K) int {
+			return cmp.Compare(reflect.ValueOf(a).String(), reflect.ValueOf(b).String())
+		}
+	default:
+		return nil
+	}
 }
 
+var ErrNoComparator = errors.New("ordered map: comparator is not set, use NewOrderedMapFunc")
+
+// initComparator sets the natural comparator for the zero value map,
+// it reports whether the map has a comparator
+func (m *OrderedMap[K, V]) initComparator() bool {
+	if m.compare == nil {
+		m.compare = naturalCompare[K]()
+	}
+	return m.compare != nil
+}
+
+// Insert adds the key-value pair to the map.
+// If the key is already present, its value is replaced.
 func (m *OrderedMap[K, V]) Insert(key K, value V) {
-	m.size++
+	if !m.initComparator() {
+		panic(ErrNoComparator)
+	}
 	m.root = m.put(m.root, key, value)
 }
 
@@ -110,7 +403,6 @@ func (m *OrderedMap[K, V]) Erase(key K) {
 	if !m.has(m.root, key) {
 		return
 	}
-	m.size--
 	m.root = m.del(m.root, key)
 }
 
@@ -122,6 +414,88 @@ func (m *OrderedMap[K, V]) Size() int {
 	return m.size
 }
 
+// Height returns height of the underlying tree, 0 for the empty map.
+func (m *OrderedMap[K, V]) Height() int {
+	return m.root.getHeight()
+}
+
 func (m *OrderedMap[K, V]) ForEach(action func(K, V)) {
-	m.inOrderTraverse(m.root, action)
+	for k, v := range m.All() {
+		action(k, v)
+	}
+}
+
+// Get returns the value stored for the key and whether the key is present.
+func (m *OrderedMap[K, V]) Get(key K) (value V, ok bool) {
+	if n := m.get(m.root, key); n != nil {
+		return n.value, true
+	}
+	return
+}
+
+// Min returns the entry with the smallest key, ok is false for the empty map.
+func (m *OrderedMap[K, V]) Min() (key K, value V, ok bool) {
+	if m.root == nil {
+		return
+	}
+
+	current := m.root
+	for current.left != nil {
+		current = current.left
+	}
+
+	return entry(current)
+}
+
+// Max returns the entry with the largest key, ok is false for the empty map.
+func (m *OrderedMap[K, V]) Max() (key K, value V, ok bool) {
+	if m.root == nil {
+		return
+	}
+
+	current := m.root
+	for current.right != nil {
+		current = current.right
+	}
+
+	return entry(current)
+}
+
+// Floor returns the entry with the greatest key less than or equal to the given one.
+func (m *OrderedMap[K, V]) Floor(key K) (K, V, bool) {
+	return entry(m.floor(m.root, key))
+}
+
+// Ceiling returns the entry with the least key greater than or equal to the given one.
+func (m *OrderedMap[K, V]) Ceiling(key K) (K, V, bool) {
+	return entry(m.ceiling(m.root, key))
+}
+
+// Rank returns the number of keys strictly less than the given one.
+// The key itself doesn't have to be present in the map.
+func (m *OrderedMap[K, V]) Rank(key K) int {
+	return m.rank(m.root, key)
+}
+
+// Select returns the entry with the i-th smallest key (0-based),
+// ok is false if i is out of [0, Size()).
+func (m *OrderedMap[K, V]) Select(i int) (K, V, bool) {
+	return entry(m.sel(m.root, i))
+}
+
+// Range calls action in key order for every entry with lo <= key <= hi.
+func (m *OrderedMap[K, V]) Range(lo, hi K, action func(K, V)) {
+	m.rangeTraverse(m.root, lo, hi, action)
+}
+
+// Snapshot returns a read-only point-in-time view of the map.
+// It takes O(1), the snapshot shares nodes with the map and every
+// subsequent write to the map copies only the O(log n) nodes on its path.
+func (m *OrderedMap[K, V]) Snapshot() Snapshot[K, V] {
+	snapshot := Snapshot[K, V]{
+		m: OrderedMap[K, V]{size: m.size, version: m.version, compare: m.compare, root: m.root},
+	}
+	m.version++
+
+	return snapshot
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Binary layout of the map, integers are big-endian:
+//
+//	magic   [4]byte "OMAP"
+//	version uint16
+//	count   uint64  number of entries
+//	length  uint64  length of the payload in bytes
+//	payload         gob stream of key, value pairs in key order
+const binaryFormatVersion uint16 = 1
+
+const (
+	// maxPayloadLength limits the payload the header may announce
+	maxPayloadLength = 1 << 30
+	// maxPrealloc limits the entries preallocated before they are read,
+	// the header is not trusted until the payload is decoded
+	maxPrealloc = 1 << 10
+)
+
+var binaryMagic = [4]byte{'O', 'M', 'A', 'P'}
+
+type binaryHeader struct {
+	Magic   [4]byte
+	Version uint16
+	Count   uint64
+	Length  uint64
+}
+
+var (
+	ErrInvalidFormat      = errors.New("ordered map: invalid binary format")
+	ErrUnsupportedVersion = errors.New("ordered map: unsupported binary format version")
+	ErrUnsortedKeys       = errors.New("ordered map: keys are not strictly increasing")
+)
+
+// build creates balanced tree from the entries sorted by key
+func (m *OrderedMap[K, V]) build(keys []K, values []V) *node[K, V] {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	mid := len(keys) / 2
+	current := &node[K, V]{key: keys[mid], value: values[mid], version: m.version}
+	current.left = m.build(keys[:mid], values[:mid])
+	current.right = m.build(keys[mid+1:], values[mid+1:])
+	current.update()
+
+	return current
+}
+
+// WriteTo writes the map to w in the versioned binary format.
+// Keys and values are encoded with encoding/gob, so they have to be
+// gob encodable. It implements io.WriterTo.
+func (m *OrderedMap[K, V]) WriteTo(w io.Writer) (int64, error) {
+	var payload bytes.Buffer
+	enc := gob.NewEncoder(&payload)
+	for k, v := range m.All() {
+		if err := enc.Encode(k); err != nil {
+			return 0, fmt.Errorf("encode key: %w", err)
+		}
+		if err := enc.Encode(v); err != nil {
+			return 0, fmt.Errorf("encode value: %w", err)
+		}
+	}
+
+	header := binaryHeader{
+		Magic:   binaryMagic,
+		Version: binaryFormatVersion,
+		Count:   uint64(m.size),
+		Length:  uint64(payload.Len()),
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(binary.Size(header) + payload.Len())
+	_ = binary.Write(&buf, binary.BigEndian, header)
+	buf.Write(payload.Bytes())
+
+	return buf.WriteTo(w)
+}
+
+// ReadFrom replaces content of the map with the one read from r in the
+// format written by WriteTo. Keys must be strictly increasing according
+// to the map comparator. The tree is built directly from the sorted
+// stream in O(n). A payload longer than 1 GiB is rejected as invalid.
+// It implements io.ReaderFrom.
+func (m *OrderedMap[K, V]) ReadFrom(r io.Reader) (int64, error) {
+	if !m.initComparator() {
+		return 0, ErrNoComparator
+	}
+
+	var header binaryHeader
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return 0, fmt.Errorf("%w: read header: %w", ErrInvalidFormat, err)
+	}
+
+	read := int64(binary.Size(header))
+
+	if header.Magic != binaryMagic {
+		return read, ErrInvalidFormat
+	}
+
+	if header.Version != binaryFormatVersion {
+		return read, fmt.Errorf("%w: %d", ErrUnsupportedVersion, header.Version)
+	}
+
+	// every entry takes at least one byte of the payload
+	if header.Length > maxPayloadLength || header.Count > header.Length {
+		return read, fmt.Errorf("%w: %d entries in %d bytes", ErrInvalidFormat, header.Count, header.Length)
+	}
+
+	capacity := min(header.Count, maxPrealloc)
+	keys := make([]K, 0, capacity)
+	values := make([]V, 0, capacity)
+
+	payload := &io.LimitedReader{R: r, N: int64(header.Length)}
+	dec := gob.NewDecoder(payload)
+	for i := uint64(0); i < header.Count; i++ {
+		var (
+			k K
+			v V
+		)
+
+		if err := dec.Decode(&k); err != nil {
+			return read, fmt.Errorf("%w: decode key: %w", ErrInvalidFormat, err)
+		}
+		if err := dec.Decode(&v); err != nil {
+			return read, fmt.Errorf("%w: decode value: %w", ErrInvalidFormat, err)
+		}
+
+		if len(keys) > 0 && m.compare(keys[len(keys)-1], k) >= 0 {
+			return read, ErrUnsortedKeys
+		}
+
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+
+	// decoder may buffer ahead, drain the rest of the payload to leave
+	// r right after the map
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		return read, fmt.Errorf("%w: read payload: %w", ErrInvalidFormat, err)
+	}
+
+	m.root = m.build(keys, values)
+	m.size = len(keys)
+
+	return read + int64(header.Length), nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (m *OrderedMap[K, V]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// The map has to be created by a constructor to have a comparator.
+func (m *OrderedMap[K, V]) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := m.ReadFrom(r); err != nil {
+		return err
+	}
+
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrInvalidFormat, r.Len())
+	}
+
+	return nil
+}
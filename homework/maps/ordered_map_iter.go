@@ -0,0 +1,106 @@
+package main
+
+import (
+	"iter"
+)
+
+// pushLeft pushes current and its left spine onto the stack
+func pushLeft[K, V any](stack []*node[K, V], current *node[K, V]) []*node[K, V] {
+	for ; current != nil; current = current.left {
+		stack = append(stack, current)
+	}
+	return stack
+}
+
+// pushRight pushes current and its right spine onto the stack
+func pushRight[K, V any](stack []*node[K, V], current *node[K, V]) []*node[K, V] {
+	for ; current != nil; current = current.right {
+		stack = append(stack, current)
+	}
+	return stack
+}
+
+// ascend yields nodes in key order, stack has to be prepared by pushLeft
+func ascend[K, V any](stack []*node[K, V], yield func(K, V) bool) {
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !yield(current.key, current.value) {
+			return
+		}
+
+		stack = pushLeft(stack, current.right)
+	}
+}
+
+// descend yields nodes in reverse key order, stack has to be prepared by pushRight
+func descend[K, V any](stack []*node[K, V], yield func(K, V) bool) {
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if !yield(current.key, current.value) {
+			return
+		}
+
+		stack = pushRight(stack, current.left)
+	}
+}
+
+// All returns an iterator over the entries in key order.
+// The map must not be modified during the iteration.
+func (m *OrderedMap[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		ascend(pushLeft(make([]*node[K, V], 0, m.Height()), m.root), yield)
+	}
+}
+
+// Keys returns an iterator over the keys in ascending order.
+func (m *OrderedMap[K, V]) Keys() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		for k := range m.All() {
+			if !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// Values returns an iterator over the values in key order.
+func (m *OrderedMap[K, V]) Values() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for _, v := range m.All() {
+			if !yield(v) {
+				return
+			}
+		}
+	}
+}
+
+// Backward returns an iterator over the entries in descending key order.
+func (m *OrderedMap[K, V]) Backward() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		descend(pushRight(make([]*node[K, V], 0, m.Height()), m.root), yield)
+	}
+}
+
+// From returns an iterator over the entries with keys greater than
+// or equal to the given one in key order.
+func (m *OrderedMap[K, V]) From(key K) iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		// keep only ancestors which keys are not less than the key,
+		// so the top of the stack is the ceiling of the key
+		stack := make([]*node[K, V], 0, m.Height())
+		for current := m.root; current != nil; {
+			if m.compare(key, current.key) <= 0 {
+				stack = append(stack, current)
+				current = current.left
+			} else {
+				current = current.right
+			}
+		}
+
+		ascend(stack, yield)
+	}
+}
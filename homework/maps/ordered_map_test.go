@@ -1,7 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"maps"
+	"math"
+	"math/rand"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -195,3 +204,677 @@ func TestOrderedMap2(t *testing.T) {
 		assert.Equal(t, []int{10, 25, 30, 50, 60, 75, 90}, keys)
 	})
 }
+
+// checkAVL verifies order, heights and balance of every node
+// and returns number of nodes in the subtree
+func checkAVL[K cmp.Ordered, V any](t *testing.T, n *node[K, V]) int {
+	t.Helper()
+
+	if n == nil {
+		return 0
+	}
+
+	if n.left != nil {
+		assert.Less(t, n.left.key, n.key)
+	}
+	if n.right != nil {
+		assert.Greater(t, n.right.key, n.key)
+	}
+
+	assert.Equal(t, 1+max(n.left.getHeight(), n.right.getHeight()), n.height)
+	assert.Equal(t, 1+n.left.getSize()+n.right.getSize(), n.size)
+	assert.LessOrEqual(t, n.balanceFactor(), 1)
+	assert.GreaterOrEqual(t, n.balanceFactor(), -1)
+
+	return 1 + checkAVL(t, n.left) + checkAVL(t, n.right)
+}
+
+func TestOrderedMapBalance(t *testing.T) {
+	t.Run("Empty Height", func(t *testing.T) {
+		m := NewOrderedMap[int, int]()
+		assert.Zero(t, m.Height())
+	})
+
+	t.Run("Sorted Inserts", func(t *testing.T) {
+		m := NewOrderedMap[int, int]()
+		n := 1_000_000
+		for i := 0; i < n; i++ {
+			m.Insert(i, i)
+		}
+
+		assert.Equal(t, n, m.Size())
+		// AVL tree height is less than 1.44 * log2(n + 2)
+		assert.Less(t, float64(m.Height()), 1.44*math.Log2(float64(n+2)))
+	})
+
+	t.Run("Reverse Sorted Inserts And Erases", func(t *testing.T) {
+		m := NewOrderedMap[int, int]()
+		n := 10000
+		for i := n; i > 0; i-- {
+			m.Insert(i, i)
+		}
+		assert.Equal(t, n, checkAVL(t, m.root))
+
+		for i := 1; i <= n; i += 2 {
+			m.Erase(i)
+		}
+		assert.Equal(t, n/2, m.Size())
+		assert.Equal(t, n/2, checkAVL(t, m.root))
+		assert.Less(t, float64(m.Height()), 1.44*math.Log2(float64(n/2+2)))
+	})
+
+	t.Run("Random Inserts And Erases", func(t *testing.T) {
+		m := NewOrderedMap[int, int]()
+		expected := map[int]int{}
+		r := rand.New(rand.NewSource(42))
+		for i := 0; i < 20000; i++ {
+			k := r.Intn(1000)
+			if r.Intn(3) == 0 {
+				m.Erase(k)
+				delete(expected, k)
+			} else {
+				m.Insert(k, i)
+				expected[k] = i
+			}
+		}
+
+		assert.Equal(t, len(expected), m.Size())
+		assert.Equal(t, len(expected), checkAVL(t, m.root))
+
+		actual := map[int]int{}
+		m.ForEach(func(k, v int) {
+			actual[k] = v
+		})
+		assert.Equal(t, expected, actual)
+	})
+
+	t.Run("Insert Existing Key Replaces Value", func(t *testing.T) {
+		m := NewOrderedMap[int, string]()
+		m.Insert(1, "one")
+		m.Insert(1, "uno")
+		assert.Equal(t, 1, m.Size())
+
+		m.ForEach(func(_ int, v string) {
+			assert.Equal(t, "uno", v)
+		})
+	})
+}
+
+func TestOrderedMapQueries(t *testing.T) {
+	newMap := func(keys ...int) OrderedMap[int, string] {
+		m := NewOrderedMap[int, string]()
+		for _, k := range keys {
+			m.Insert(k, strconv.Itoa(k))
+		}
+		return m
+	}
+
+	t.Run("Empty Map", func(t *testing.T) {
+		m := newMap()
+
+		_, ok := m.Get(1)
+		assert.False(t, ok)
+		_, _, ok = m.Min()
+		assert.False(t, ok)
+		_, _, ok = m.Max()
+		assert.False(t, ok)
+		_, _, ok = m.Floor(1)
+		assert.False(t, ok)
+		_, _, ok = m.Ceiling(1)
+		assert.False(t, ok)
+		_, _, ok = m.Select(0)
+		assert.False(t, ok)
+		assert.Zero(t, m.Rank(1))
+
+		m.Range(0, 10, func(int, string) {
+			assert.Fail(t, "unexpected call")
+		})
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		m := newMap(10, 5, 15)
+
+		v, ok := m.Get(5)
+		assert.True(t, ok)
+		assert.Equal(t, "5", v)
+
+		_, ok = m.Get(7)
+		assert.False(t, ok)
+	})
+
+	t.Run("Min Max", func(t *testing.T) {
+		m := newMap(10, 5, 15, 2, 4, 12, 14)
+
+		k, v, ok := m.Min()
+		assert.True(t, ok)
+		assert.Equal(t, 2, k)
+		assert.Equal(t, "2", v)
+
+		k, v, ok = m.Max()
+		assert.True(t, ok)
+		assert.Equal(t, 15, k)
+		assert.Equal(t, "15", v)
+	})
+
+	t.Run("Floor Ceiling", func(t *testing.T) {
+		m := newMap(10, 20, 30, 40, 50)
+
+		tests := []struct {
+			key          int
+			floor        int
+			floorFound   bool
+			ceiling      int
+			ceilingFound bool
+		}{
+			{key: 5, ceiling: 10, ceilingFound: true},
+			{key: 10, floor: 10, floorFound: true, ceiling: 10, ceilingFound: true},
+			{key: 25, floor: 20, floorFound: true, ceiling: 30, ceilingFound: true},
+			{key: 49, floor: 40, floorFound: true, ceiling: 50, ceilingFound: true},
+			{key: 55, floor: 50, floorFound: true},
+		}
+
+		for _, test := range tests {
+			k, _, ok := m.Floor(test.key)
+			assert.Equal(t, test.floorFound, ok)
+			assert.Equal(t, test.floor, k)
+
+			k, _, ok = m.Ceiling(test.key)
+			assert.Equal(t, test.ceilingFound, ok)
+			assert.Equal(t, test.ceiling, k)
+		}
+	})
+
+	t.Run("Rank Select", func(t *testing.T) {
+		keys := []int{50, 25, 75, 10, 30, 60, 90}
+		m := newMap(keys...)
+		sorted := slices.Sorted(slices.Values(keys))
+
+		for i, k := range sorted {
+			assert.Equal(t, i, m.Rank(k))
+
+			selected, v, ok := m.Select(i)
+			assert.True(t, ok)
+			assert.Equal(t, k, selected)
+			assert.Equal(t, strconv.Itoa(k), v)
+		}
+
+		assert.Equal(t, 0, m.Rank(0))
+		assert.Equal(t, 3, m.Rank(49))
+		assert.Equal(t, 7, m.Rank(100))
+
+		_, _, ok := m.Select(-1)
+		assert.False(t, ok)
+		_, _, ok = m.Select(len(keys))
+		assert.False(t, ok)
+	})
+
+	t.Run("Rank Select After Erase", func(t *testing.T) {
+		m := newMap()
+		for i := 0; i < 1000; i++ {
+			m.Insert(i, strconv.Itoa(i))
+		}
+		for i := 0; i < 1000; i += 2 {
+			m.Erase(i)
+		}
+
+		for i := 0; i < 500; i++ {
+			k, _, ok := m.Select(i)
+			assert.True(t, ok)
+			assert.Equal(t, 2*i+1, k)
+			assert.Equal(t, i, m.Rank(2*i+1))
+		}
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		m := newMap(10, 5, 15, 2, 4, 12, 14)
+
+		var keys []int
+		m.Range(4, 12, func(k int, _ string) {
+			keys = append(keys, k)
+		})
+		assert.Equal(t, []int{4, 5, 10, 12}, keys)
+
+		keys = nil
+		m.Range(6, 9, func(k int, _ string) {
+			keys = append(keys, k)
+		})
+		assert.Empty(t, keys)
+
+		keys = nil
+		m.Range(-100, 100, func(k int, _ string) {
+			keys = append(keys, k)
+		})
+		assert.Equal(t, []int{2, 4, 5, 10, 12, 14, 15}, keys)
+	})
+}
+
+func TestOrderedMapIterators(t *testing.T) {
+	m := NewOrderedMap[int, string]()
+	for _, k := range []int{10, 5, 15, 2, 4, 12, 14} {
+		m.Insert(k, strconv.Itoa(k))
+	}
+
+	t.Run("Empty Map", func(t *testing.T) {
+		empty := NewOrderedMap[int, string]()
+		assert.Empty(t, slices.Collect(empty.Keys()))
+		assert.Empty(t, slices.Collect(empty.Values()))
+		assert.Empty(t, maps.Collect(empty.All()))
+		assert.Empty(t, maps.Collect(empty.Backward()))
+		assert.Empty(t, maps.Collect(empty.From(1)))
+	})
+
+	t.Run("Keys Values", func(t *testing.T) {
+		assert.Equal(t, []int{2, 4, 5, 10, 12, 14, 15}, slices.Collect(m.Keys()))
+		assert.Equal(t, []string{"2", "4", "5", "10", "12", "14", "15"}, slices.Collect(m.Values()))
+	})
+
+	t.Run("All", func(t *testing.T) {
+		expected := map[int]string{2: "2", 4: "4", 5: "5", 10: "10", 12: "12", 14: "14", 15: "15"}
+		assert.Equal(t, expected, maps.Collect(m.All()))
+	})
+
+	t.Run("Backward", func(t *testing.T) {
+		var keys []int
+		for k := range m.Backward() {
+			keys = append(keys, k)
+		}
+		assert.Equal(t, []int{15, 14, 12, 10, 5, 4, 2}, keys)
+	})
+
+	t.Run("From", func(t *testing.T) {
+		tests := []struct {
+			key      int
+			expected []int
+		}{
+			{key: 0, expected: []int{2, 4, 5, 10, 12, 14, 15}},
+			{key: 5, expected: []int{5, 10, 12, 14, 15}},
+			{key: 11, expected: []int{12, 14, 15}},
+			{key: 15, expected: []int{15}},
+			{key: 16, expected: nil},
+		}
+
+		for _, test := range tests {
+			var keys []int
+			for k := range m.From(test.key) {
+				keys = append(keys, k)
+			}
+			assert.Equal(t, test.expected, keys)
+		}
+	})
+
+	t.Run("Break", func(t *testing.T) {
+		var keys []int
+		for k := range m.Keys() {
+			if k > 5 {
+				break
+			}
+			keys = append(keys, k)
+		}
+		assert.Equal(t, []int{2, 4, 5}, keys)
+
+		keys = nil
+		for k := range m.Backward() {
+			if k < 12 {
+				break
+			}
+			keys = append(keys, k)
+		}
+		assert.Equal(t, []int{15, 14, 12}, keys)
+
+		keys = nil
+		for k := range m.From(5) {
+			keys = append(keys, k)
+			if len(keys) == 2 {
+				break
+			}
+		}
+		assert.Equal(t, []int{5, 10}, keys)
+	})
+
+	t.Run("Large Sorted", func(t *testing.T) {
+		large := NewOrderedMap[int, int]()
+		n := 100000
+		for i := 0; i < n; i++ {
+			large.Insert(i, i)
+		}
+
+		keys := slices.Collect(large.Keys())
+		assert.Equal(t, n, len(keys))
+		assert.True(t, slices.IsSorted(keys))
+	})
+}
+
+// countVersion returns number of nodes created in the given version
+func countVersion[K cmp.Ordered, V any](n *node[K, V], version uint64) int {
+	if n == nil {
+		return 0
+	}
+
+	count := countVersion(n.left, version) + countVersion(n.right, version)
+	if n.version == version {
+		count++
+	}
+
+	return count
+}
+
+func TestOrderedMapSnapshot(t *testing.T) {
+	t.Run("Snapshot Of Empty Map", func(t *testing.T) {
+		m := NewOrderedMap[int, string]()
+		s := m.Snapshot()
+		m.Insert(1, "one")
+
+		assert.Zero(t, s.Size())
+		assert.False(t, s.Contains(1))
+		assert.Empty(t, slices.Collect(s.Keys()))
+	})
+
+	t.Run("Snapshot Is Isolated From Writes", func(t *testing.T) {
+		m := NewOrderedMap[int, string]()
+		for i := 0; i < 100; i++ {
+			m.Insert(i, strconv.Itoa(i))
+		}
+
+		s := m.Snapshot()
+
+		for i := 0; i < 100; i += 2 {
+			m.Erase(i)
+		}
+		for i := 100; i < 200; i++ {
+			m.Insert(i, strconv.Itoa(i))
+		}
+		m.Insert(1, "one")
+
+		assert.Equal(t, 100, s.Size())
+		assert.Equal(t, 100, checkAVL(t, s.m.root))
+		for i := 0; i < 100; i++ {
+			v, ok := s.Get(i)
+			assert.True(t, ok)
+			assert.Equal(t, strconv.Itoa(i), v)
+		}
+		assert.False(t, s.Contains(150))
+
+		assert.Equal(t, 150, m.Size())
+		assert.Equal(t, 150, checkAVL(t, m.root))
+		v, _ := m.Get(1)
+		assert.Equal(t, "one", v)
+		assert.False(t, m.Contains(0))
+	})
+
+	t.Run("Several Snapshots", func(t *testing.T) {
+		m := NewOrderedMap[int, int]()
+		var snapshots []Snapshot[int, int]
+		for i := 0; i < 10; i++ {
+			m.Insert(i, i)
+			snapshots = append(snapshots, m.Snapshot())
+		}
+
+		for i, s := range snapshots {
+			assert.Equal(t, i+1, s.Size())
+			k, _, ok := s.Max()
+			assert.True(t, ok)
+			assert.Equal(t, i, k)
+		}
+	})
+
+	t.Run("Write Copies Only Path", func(t *testing.T) {
+		m := NewOrderedMap[int, int]()
+		n := 1_000_000
+		for i := 0; i < n; i++ {
+			m.Insert(i, i)
+		}
+
+		s := m.Snapshot()
+		m.Insert(n, n)
+		assert.LessOrEqual(t, countVersion(m.root, m.version), m.Height()+2)
+
+		m.Erase(n / 2)
+		assert.LessOrEqual(t, countVersion(m.root, m.version), 3*m.Height())
+
+		assert.Equal(t, n, s.Size())
+		assert.True(t, s.Contains(n/2))
+		assert.False(t, s.Contains(n))
+	})
+
+	t.Run("Writes Without Snapshot Are In Place", func(t *testing.T) {
+		m := NewOrderedMap[int, int]()
+		for i := 0; i < 100; i++ {
+			m.Insert(i, i)
+		}
+
+		root := m.root
+		m.Insert(50, 500)
+		assert.Same(t, root, m.root)
+	})
+}
+
+func TestOrderedMapFunc(t *testing.T) {
+	t.Run("Descending Order", func(t *testing.T) {
+		m := NewOrderedMapFunc[int, string](func(a, b int) int {
+			return cmp.Compare(b, a)
+		})
+		for _, k := range []int{10, 5, 15, 2, 4, 12, 14} {
+			m.Insert(k, strconv.Itoa(k))
+		}
+
+		assert.Equal(t, []int{15, 14, 12, 10, 5, 4, 2}, slices.Collect(m.Keys()))
+
+		k, _, _ := m.Min()
+		assert.Equal(t, 15, k)
+		k, _, _ = m.Floor(11)
+		assert.Equal(t, 12, k)
+		k, _, _ = m.Ceiling(11)
+		assert.Equal(t, 10, k)
+		assert.Equal(t, 2, m.Rank(12))
+
+		var keys []int
+		m.Range(12, 4, func(k int, _ string) {
+			keys = append(keys, k)
+		})
+		assert.Equal(t, []int{12, 10, 5, 4}, keys)
+
+		m.Erase(10)
+		assert.False(t, m.Contains(10))
+		keys = nil
+		for k := range m.From(12) {
+			keys = append(keys, k)
+		}
+		assert.Equal(t, []int{12, 5, 4, 2}, keys)
+	})
+
+	t.Run("Struct Keys", func(t *testing.T) {
+		type point struct {
+			x, y int
+		}
+
+		m := NewOrderedMapFunc[point, string](func(a, b point) int {
+			return cmp.Or(cmp.Compare(a.x, b.x), cmp.Compare(a.y, b.y))
+		})
+		m.Insert(point{1, 2}, "b")
+		m.Insert(point{0, 5}, "a")
+		m.Insert(point{1, 1}, "c")
+		m.Insert(point{1, 2}, "d")
+
+		assert.Equal(t, 3, m.Size())
+		assert.True(t, m.Contains(point{1, 1}))
+		assert.False(t, m.Contains(point{2, 1}))
+		assert.Equal(t, []point{{0, 5}, {1, 1}, {1, 2}}, slices.Collect(m.Keys()))
+
+		v, ok := m.Get(point{1, 2})
+		assert.True(t, ok)
+		assert.Equal(t, "d", v)
+	})
+
+	t.Run("Case Insensitive Keys", func(t *testing.T) {
+		m := NewOrderedMapFunc[string, int](func(a, b string) int {
+			return cmp.Compare(strings.ToLower(a), strings.ToLower(b))
+		})
+		m.Insert("b", 1)
+		m.Insert("A", 2)
+		m.Insert("B", 3)
+
+		assert.Equal(t, 2, m.Size())
+		assert.True(t, m.Contains("a"))
+		assert.Equal(t, []int{2, 3}, slices.Collect(m.Values()))
+	})
+
+	t.Run("Snapshot Keeps Comparator", func(t *testing.T) {
+		m := NewOrderedMapFunc[int, int](func(a, b int) int {
+			return cmp.Compare(b, a)
+		})
+		m.Insert(1, 1)
+		m.Insert(2, 2)
+
+		s := m.Snapshot()
+		assert.True(t, s.Contains(2))
+		k, _, _ := s.Select(0)
+		assert.Equal(t, 2, k)
+	})
+}
+
+func TestOrderedMapZeroValue(t *testing.T) {
+	t.Run("Ordered Keys", func(t *testing.T) {
+		var m OrderedMap[int, int]
+		for _, k := range []int{10, 5, 15, 2, 4, 12, 14} {
+			m.Insert(k, k)
+		}
+		m.Erase(5)
+
+		assert.Equal(t, []int{2, 4, 10, 12, 14, 15}, slices.Collect(m.Keys()))
+		assert.True(t, m.Contains(12))
+
+		type name string
+		var names OrderedMap[name, int]
+		names.Insert("b", 2)
+		names.Insert("a", 1)
+		names.Insert("c", 3)
+		assert.Equal(t, []name{"a", "b", "c"}, slices.Collect(names.Keys()))
+
+		var floats OrderedMap[float64, int]
+		floats.Insert(1.5, 1)
+		floats.Insert(-2.5, 2)
+		assert.Equal(t, []float64{-2.5, 1.5}, slices.Collect(floats.Keys()))
+	})
+
+	t.Run("Unordered Keys", func(t *testing.T) {
+		var m OrderedMap[[2]int, int]
+		assert.False(t, m.Contains([2]int{1, 2}))
+		assert.PanicsWithValue(t, ErrNoComparator, func() {
+			m.Insert([2]int{1, 2}, 1)
+		})
+	})
+}
+
+func TestOrderedMapBinary(t *testing.T) {
+	t.Run("Round Trip", func(t *testing.T) {
+		m := NewOrderedMap[int, string]()
+		for i := 0; i < 1000; i++ {
+			m.Insert(i*3, strconv.Itoa(i))
+		}
+		m.Insert(0, "")
+
+		var buf bytes.Buffer
+		written, err := m.WriteTo(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(buf.Len()), written)
+
+		// trailing data has to stay in the reader
+		buf.WriteString("tail")
+
+		loaded := NewOrderedMap[int, string]()
+		loaded.Insert(-1, "replaced")
+		read, err := loaded.ReadFrom(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, written, read)
+		assert.Equal(t, "tail", buf.String())
+
+		assert.Equal(t, m.Size(), loaded.Size())
+		assert.Equal(t, maps.Collect(m.All()), maps.Collect(loaded.All()))
+		assert.Equal(t, loaded.Size(), checkAVL(t, loaded.root))
+
+		loaded.Insert(1, "one")
+		loaded.Erase(3)
+		assert.Equal(t, loaded.Size(), checkAVL(t, loaded.root))
+	})
+
+	t.Run("Empty Map", func(t *testing.T) {
+		m := NewOrderedMap[string, int]()
+		data, err := m.MarshalBinary()
+		assert.NoError(t, err)
+
+		loaded := NewOrderedMap[string, int]()
+		assert.NoError(t, loaded.UnmarshalBinary(data))
+		assert.Zero(t, loaded.Size())
+	})
+
+	t.Run("Struct Values And Custom Comparator", func(t *testing.T) {
+		type item struct {
+			Name  string
+			Count int
+		}
+
+		descending := func(a, b string) int {
+			return cmp.Compare(b, a)
+		}
+
+		m := NewOrderedMapFunc[string, item](descending)
+		m.Insert("a", item{Name: "apple", Count: 1})
+		m.Insert("b", item{Name: "banana"})
+		m.Insert("c", item{})
+
+		data, err := m.MarshalBinary()
+		assert.NoError(t, err)
+
+		loaded := NewOrderedMapFunc[string, item](descending)
+		assert.NoError(t, loaded.UnmarshalBinary(data))
+		assert.Equal(t, []string{"c", "b", "a"}, slices.Collect(loaded.Keys()))
+		v, _ := loaded.Get("a")
+		assert.Equal(t, item{Name: "apple", Count: 1}, v)
+
+		ascending := NewOrderedMap[string, item]()
+		assert.ErrorIs(t, ascending.UnmarshalBinary(data), ErrUnsortedKeys)
+	})
+
+	t.Run("Invalid Data", func(t *testing.T) {
+		m := NewOrderedMap[int, int]()
+		for i := 0; i < 10; i++ {
+			m.Insert(i, i)
+		}
+		data, err := m.MarshalBinary()
+		assert.NoError(t, err)
+
+		loaded := NewOrderedMap[int, int]()
+		assert.ErrorIs(t, loaded.UnmarshalBinary(nil), ErrInvalidFormat)
+		assert.ErrorIs(t, loaded.UnmarshalBinary(data[:len(data)-1]), ErrInvalidFormat)
+		assert.ErrorIs(t, loaded.UnmarshalBinary(append(slices.Clone(data), 0)), ErrInvalidFormat)
+
+		badMagic := slices.Clone(data)
+		badMagic[0] = 'X'
+		assert.ErrorIs(t, loaded.UnmarshalBinary(badMagic), ErrInvalidFormat)
+
+		badVersion := slices.Clone(data)
+		badVersion[5] = 2
+		assert.ErrorIs(t, loaded.UnmarshalBinary(badVersion), ErrUnsupportedVersion)
+
+		// header is 22 bytes: magic, version, count at 6 and length at 14
+		hugeCount := slices.Clone(data)
+		binary.BigEndian.PutUint64(hugeCount[6:], 1<<40)
+		assert.ErrorIs(t, loaded.UnmarshalBinary(hugeCount), ErrInvalidFormat)
+
+		hugeHeader := slices.Clone(data[:22])
+		binary.BigEndian.PutUint64(hugeHeader[6:], 1<<40)
+		binary.BigEndian.PutUint64(hugeHeader[14:], 1<<40)
+		assert.ErrorIs(t, loaded.UnmarshalBinary(hugeHeader), ErrInvalidFormat)
+
+		binary.BigEndian.PutUint64(hugeHeader[6:], maxPayloadLength)
+		binary.BigEndian.PutUint64(hugeHeader[14:], maxPayloadLength)
+		assert.ErrorIs(t, loaded.UnmarshalBinary(hugeHeader), ErrInvalidFormat)
+
+		var zero OrderedMap[int, int]
+		assert.NoError(t, zero.UnmarshalBinary(data))
+		assert.Equal(t, m.Size(), zero.Size())
+
+		var unordered OrderedMap[[2]int, int]
+		assert.ErrorIs(t, unordered.UnmarshalBinary(data), ErrNoComparator)
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"iter"
+)
+
+// Snapshot is a read-only view of an OrderedMap taken by OrderedMap.Snapshot.
+// It is not affected by the writes to the map made after it was taken.
+type Snapshot[K, V any] struct {
+	m OrderedMap[K, V]
+}
+
+func (s Snapshot[K, V]) Contains(key K) bool {
+	return s.m.Contains(key)
+}
+
+func (s Snapshot[K, V]) Size() int {
+	return s.m.Size()
+}
+
+func (s Snapshot[K, V]) Height() int {
+	return s.m.Height()
+}
+
+func (s Snapshot[K, V]) ForEach(action func(K, V)) {
+	s.m.ForEach(action)
+}
+
+func (s Snapshot[K, V]) Get(key K) (V, bool) {
+	return s.m.Get(key)
+}
+
+func (s Snapshot[K, V]) Min() (K, V, bool) {
+	return s.m.Min()
+}
+
+func (s Snapshot[K, V]) Max() (K, V, bool) {
+	return s.m.Max()
+}
+
+func (s Snapshot[K, V]) Floor(key K) (K, V, bool) {
+	return s.m.Floor(key)
+}
+
+func (s Snapshot[K, V]) Ceiling(key K) (K, V, bool) {
+	return s.m.Ceiling(key)
+}
+
+func (s Snapshot[K, V]) Rank(key K) int {
+	return s.m.Rank(key)
+}
+
+func (s Snapshot[K, V]) Select(i int) (K, V, bool) {
+	return s.m.Select(i)
+}
+
+func (s Snapshot[K, V]) Range(lo, hi K, action func(K, V)) {
+	s.m.Range(lo, hi, action)
+}
+
+func (s Snapshot[K, V]) All() iter.Seq2[K, V] {
+	return s.m.All()
+}
+
+func (s Snapshot[K, V]) Keys() iter.Seq[K] {
+	return s.m.Keys()
+}
+
+func (s Snapshot[K, V]) Values() iter.Seq[V] {
+	return s.m.Values()
+}
+
+func (s Snapshot[K, V]) Backward() iter.Seq2[K, V] {
+	return s.m.Backward()
+}
+
+func (s Snapshot[K, V]) From(key K) iter.Seq2[K, V] {
+	return s.m.From(key)
+}
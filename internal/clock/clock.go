@@ -0,0 +1,24 @@
+// Package clock abstracts time for code which waits, so tests can
+// replace the real time with Fake.
+package clock
+
+import (
+	"time"
+)
+
+// Clock is a source of time.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real is Clock of time.Now and time.After.
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
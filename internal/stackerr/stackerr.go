@@ -0,0 +1,94 @@
+// Package stackerr provides errors carrying the stack trace of the place
+// they are created, printed by the %+v verb.
+package stackerr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+)
+
+const maxStackDepth = 32
+
+// Error is an error with the stack trace of the place it is created.
+// Only program counters are captured, frames are resolved when printed.
+type Error struct {
+	err error
+	pcs []uintptr
+}
+
+// callers captures the stack of the caller of the function calling callers
+func callers() []uintptr {
+	var pcs [maxStackDepth]uintptr
+	// skip runtime.Callers, callers and its caller
+	n := runtime.Callers(3, pcs[:])
+	return pcs[:n]
+}
+
+// WithStack adds the stack trace of the caller to the error. It returns
+// nil for nil error and the error itself if it already has a stack trace.
+func WithStack(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var stackErr *Error
+	if errors.As(err, &stackErr) {
+		return err
+	}
+
+	return &Error{err: err, pcs: callers()}
+}
+
+// New is errors.New with the stack trace of the caller.
+func New(message string) error {
+	return &Error{err: errors.New(message), pcs: callers()}
+}
+
+// Errorf is fmt.Errorf with the stack trace of the caller.
+func Errorf(format string, args ...any) error {
+	return &Error{err: fmt.Errorf(format, args...), pcs: callers()}
+}
+
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Frames resolves the stack trace, the caller goes first.
+func (e *Error) Frames() []runtime.Frame {
+	frames := runtime.CallersFrames(e.pcs)
+
+	result := make([]runtime.Frame, 0, len(e.pcs))
+	for {
+		frame, more := frames.Next()
+		result = append(result, frame)
+		if !more {
+			break
+		}
+	}
+	return result
+}
+
+// Format prints the message for %s and %v, the quoted message
+// for %q and the message followed by the stack trace for %+v.
+func (e *Error) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		_, _ = io.WriteString(s, e.Error())
+		if s.Flag('+') {
+			for _, frame := range e.Frames() {
+				_, _ = io.WriteString(s, "\n"+frame.Function+"\n\t"+frame.File+":"+strconv.Itoa(frame.Line))
+			}
+		}
+	case 's':
+		_, _ = io.WriteString(s, e.Error())
+	case 'q':
+		_, _ = fmt.Fprintf(s, "%q", e.Error())
+	}
+}
@@ -0,0 +1,90 @@
+package stackerr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// go test -v .
+
+var errRequired = errors.New("is required")
+
+func newStackError() error {
+	return WithStack(errRequired)
+}
+
+func TestError(t *testing.T) {
+	t.Run("Is And As", func(t *testing.T) {
+		err := newStackError()
+		assert.ErrorIs(t, err, errRequired)
+		assert.ErrorIs(t, fmt.Errorf("wrapped: %w", err), errRequired)
+
+		var stackErr *Error
+		assert.ErrorAs(t, fmt.Errorf("wrapped: %w", err), &stackErr)
+		assert.True(t, strings.HasSuffix(stackErr.Frames()[0].Function, ".newStackError"))
+
+		// the stack is captured once
+		assert.Same(t, err, WithStack(err))
+		assert.Nil(t, WithStack(nil))
+	})
+
+	t.Run("New", func(t *testing.T) {
+		err := New("is required")
+		assert.EqualError(t, err, "is required")
+		assert.True(t, strings.HasSuffix(err.(*Error).Frames()[0].Function, ".TestError.func2"))
+	})
+
+	t.Run("Errorf", func(t *testing.T) {
+		err := Errorf("user %d: %w", 42, errRequired)
+		assert.EqualError(t, err, "user 42: is required")
+		assert.ErrorIs(t, err, errRequired)
+		assert.True(t, strings.HasSuffix(err.(*Error).Frames()[0].Function, ".TestError.func3"))
+	})
+
+	t.Run("Format", func(t *testing.T) {
+		err := newStackError()
+
+		assert.Equal(t, "is required", fmt.Sprintf("%v", err))
+		assert.Equal(t, "is required", fmt.Sprintf("%s", err))
+		assert.Equal(t, `"is required"`, fmt.Sprintf("%q", err))
+
+		lines := strings.Split(fmt.Sprintf("%+v", err), "\n")
+		assert.Equal(t, "is required", lines[0])
+		assert.Regexp(t, `^\S+\.newStackError$`, lines[1])
+		assert.Regexp(t, `^\t.+/stackerr_test\.go:\d+$`, lines[2])
+		assert.Regexp(t, `^\S+\.TestError\.func4$`, lines[3])
+	})
+}
+
+// go test -bench=. -benchmem .
+
+var benchErr error
+
+func BenchmarkErrorWithoutStackTrace(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		benchErr = errors.New("error")
+	}
+}
+
+func BenchmarkErrorWithStackTrace(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		benchErr = New("error")
+	}
+}
+
+func BenchmarkErrorfWithStackTrace(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		benchErr = Errorf("error %d", i)
+	}
+}
+
+func BenchmarkFormatStackTrace(b *testing.B) {
+	err := New("error")
+	for i := 0; i < b.N; i++ {
+		_ = fmt.Sprintf("%+v", err)
+	}
+}
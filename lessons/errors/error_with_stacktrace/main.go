@@ -3,7 +3,7 @@ package main
 import (
 	"fmt"
 
-	"github.com/pkg/errors"
+	"golang_course/internal/stackerr"
 )
 
 func main() {
@@ -15,5 +15,5 @@ func main() {
 }
 
 func DoSomething() (string, error) {
-	return "", errors.New("some error explanation here")
+	return "", stackerr.New("some error explanation here")
 }
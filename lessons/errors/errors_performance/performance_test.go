@@ -4,7 +4,7 @@ import (
 	"errors"
 	"testing"
 
-	othererrors "github.com/pkg/errors"
+	"golang_course/internal/stackerr"
 )
 
 // go test -bench=. performance_test.go
@@ -19,6 +19,6 @@ func BenchmarkErrorWithoutStackTrace(b *testing.B) {
 
 func BenchmarkErrorWithStackTrace(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		err = othererrors.New("error")
+		err = stackerr.New("error")
 	}
 }